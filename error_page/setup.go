@@ -0,0 +1,124 @@
+package error_page
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/mholt/caddy"
+)
+
+func init() {
+	caddy.RegisterPlugin(super.DirectiveErrorPage, caddy.Plugin{
+		ServerType: super.FastHTTPServerType,
+		Action:     setup,
+	})
+}
+
+const (
+	defaultContentType = "text/html; charset=utf-8"
+)
+
+type ErrorPageConfig struct {
+	Codes []int
+	Page  super.ErrorPageConfig
+}
+
+func setup(c *caddy.Controller) error {
+	srvCfg := super.GetConfig(c)
+	if srvCfg == nil {
+		panic("[BUG] server config can't be nil")
+	}
+	// every error_page directive in the server block is dispensed together
+	for c.Next() {
+		cfg, err := parseConfig(c)
+		if err != nil {
+			return err
+		}
+		if cfg.Page.File != "" {
+			fileInfo, err := os.Stat(cfg.Page.File)
+			if err != nil {
+				return c.Errf("%s path err: %s", cfg.Page.File, err)
+			}
+			if fileInfo.IsDir() {
+				return c.Errf("%s is a directory", cfg.Page.File)
+			}
+		}
+		for _, code := range cfg.Codes {
+			srvCfg.AddErrorPage(code, cfg.Page)
+		}
+	}
+	return nil
+}
+
+// error_page 500 502 503 504 /var/www/50x.html
+//
+// error_page 502 503 {
+//     file /var/www/50x.html
+//     body "upstream error, request: {path}"
+//     content_type text/html
+//     code 200
+// }
+func parseConfig(c *caddy.Controller) (*ErrorPageConfig, error) {
+	cfg := ErrorPageConfig{Page: super.ErrorPageConfig{ContentType: defaultContentType}}
+	for _, arg := range c.RemainingArgs() {
+		code, err := strconv.Atoi(arg)
+		if err != nil {
+			// the trailing non-numeric argument is the page file
+			if cfg.Page.File != "" {
+				return nil, c.ArgErr()
+			}
+			cfg.Page.File = arg
+			continue
+		}
+		if cfg.Page.File != "" {
+			return nil, c.ArgErr()
+		}
+		if code < 300 || code > 599 {
+			return nil, c.Errf("status code %d isn't an error", code)
+		}
+		cfg.Codes = append(cfg.Codes, code)
+	}
+	if len(cfg.Codes) == 0 {
+		return nil, c.ArgErr()
+	}
+	for c.NextBlock() {
+		kind := c.Val()
+		switch strings.ToLower(kind) {
+		case "code":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			code, err := strconv.Atoi(c.Val())
+			if err != nil {
+				return nil, c.Err(err.Error())
+			}
+			cfg.Page.StatusCode = code
+		case "content_type":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			cfg.Page.ContentType = c.Val()
+		case "body":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			cfg.Page.Body = c.Val()
+		case "file":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			cfg.Page.File = c.Val()
+		default:
+			return nil, c.Errf("[error_page] illegal directive %s", kind)
+		}
+	}
+	if cfg.Page.File != "" && cfg.Page.Body != "" {
+		return nil, c.Err("cannot specify file and body at the same time")
+	}
+	if cfg.Page.File == "" && cfg.Page.Body == "" {
+		return nil, c.Err("either file or body is required")
+	}
+	return &cfg, nil
+}
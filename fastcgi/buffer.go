@@ -0,0 +1,151 @@
+package fastcgi
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+const (
+	defaultBufferSize      = 64 * 1024
+	defaultMaxTempFileSize = 1024 * 1024 * 1024
+)
+
+// fcgiResult is a response from backend. Small body is kept in memory, larger one
+// is read from stream, which is either a temp file or the connection to backend
+type fcgiResult struct {
+	status int
+	header http.Header
+	body   []byte
+	stream io.ReadCloser
+	// size of stream, -1 means unknown
+	size int
+	// free puts the buffer of body back to the pool
+	free func()
+}
+
+// discard releases the resources held by the result which won't be sent
+func (r *fcgiResult) discard() {
+	if r == nil {
+		return
+	}
+	if r.stream != nil {
+		r.stream.Close()
+		r.stream = nil
+	}
+	r.freeBody()
+}
+
+// freeBody is called once body is copied to the response or dropped
+func (r *fcgiResult) freeBody() {
+	if r.free != nil {
+		r.free()
+		r.free, r.body = nil, nil
+	}
+}
+
+// getBuffer returns a buffer of BufferSize, which is put back to h.buffers once the body in it is sent
+func (h *Handler) getBuffer() *[]byte {
+	if buf, ok := h.buffers.Get().(*[]byte); ok {
+		return buf
+	}
+	buf := make([]byte, h.BufferSize)
+	return &buf
+}
+
+// bodyStream calls done once it's closed, fasthttp closes it after the response is sent
+type bodyStream struct {
+	io.Reader
+	eof  bool
+	done func(eof bool)
+}
+
+func (s *bodyStream) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	if err == io.EOF {
+		s.eof = true
+	}
+	return n, err
+}
+
+func (s *bodyStream) Close() error {
+	if s.done != nil {
+		s.done(s.eof)
+		s.done = nil
+	}
+	return nil
+}
+
+// readBody keeps the body in memory if it isn't larger than BufferSize. Otherwise the body
+// is spilled to a temp file up to MaxTempFileSize if buffering is on, and the rest is
+// streamed from backend while sending the response, like nginx's fastcgi_buffering.
+// done is called once the connection isn't used anymore, complete reports whether the body is fully read
+func (h *Handler) readBody(resp *http.Response, done func(complete bool)) (*fcgiResult, error) {
+	res := &fcgiResult{status: resp.StatusCode, header: resp.Header, size: -1}
+	buf := h.getBuffer()
+	n, err := io.ReadFull(resp.Body, *buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		done(true)
+		res.body = (*buf)[:n]
+		res.free = func() { h.buffers.Put(buf) }
+		return res, nil
+	}
+	if err != nil {
+		h.buffers.Put(buf)
+		done(false)
+		return nil, err
+	}
+	if !h.Buffering || h.MaxTempFileSize <= n {
+		res.stream = &bodyStream{
+			Reader: io.MultiReader(bytes.NewReader(*buf), resp.Body),
+			done: func(eof bool) {
+				h.buffers.Put(buf)
+				done(eof)
+			},
+		}
+		return res, nil
+	}
+
+	f, err := ioutil.TempFile(h.TempPath, "durian-fcgi-")
+	if err != nil {
+		h.buffers.Put(buf)
+		done(false)
+		return nil, err
+	}
+	removeFile := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	var copied int64
+	_, err = f.Write(*buf)
+	h.buffers.Put(buf)
+	if err == nil {
+		copied, err = io.CopyN(f, resp.Body, int64(h.MaxTempFileSize-n))
+	}
+	complete := err == io.EOF
+	if err == nil || complete {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeFile()
+		done(false)
+		return nil, err
+	}
+	if complete {
+		// backend is done, the connection can be reused while the client is downloading
+		done(true)
+		res.size = n + int(copied)
+		res.stream = &bodyStream{Reader: f, done: func(bool) { removeFile() }}
+		return res, nil
+	}
+	res.stream = &bodyStream{
+		Reader: io.MultiReader(f, resp.Body),
+		done: func(eof bool) {
+			removeFile()
+			done(eof)
+		},
+	}
+	return res, nil
+}
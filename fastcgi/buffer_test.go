@@ -0,0 +1,71 @@
+package fastcgi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadBody(t *testing.T) {
+	should := require.New(t)
+	tmp, err := ioutil.TempDir("", "fcgi-buffer")
+	should.NoError(err)
+	defer os.RemoveAll(tmp)
+
+	body := strings.Repeat("0123456789", 10)
+	var testData = []struct {
+		buffering       bool
+		bufferSize      int
+		maxTempFileSize int
+		inMemory        bool
+		size            int
+		// whether the connection is released before the response is sent
+		doneEarly bool
+	}{
+		{buffering: true, bufferSize: 128, maxTempFileSize: 1000, inMemory: true, size: -1, doneEarly: true},
+		{buffering: true, bufferSize: 10, maxTempFileSize: 1000, size: 100, doneEarly: true},
+		{buffering: true, bufferSize: 10, maxTempFileSize: 50, size: -1},
+		{buffering: false, bufferSize: 10, maxTempFileSize: 1000, size: -1},
+	}
+	for idx, tc := range testData {
+		h := &Handler{
+			Buffering:       tc.buffering,
+			BufferSize:      tc.bufferSize,
+			MaxTempFileSize: tc.maxTempFileSize,
+			TempPath:        tmp,
+		}
+		var done, complete bool
+		res, err := h.readBody(&http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}, func(c bool) {
+			done, complete = true, c
+		})
+		should.NoError(err, "case %d fail", idx)
+		should.Equal(tc.doneEarly, done, "case %d fail", idx)
+		should.Equal(tc.size, res.size, "case %d fail", idx)
+		if tc.inMemory {
+			should.Nil(res.stream, "case %d fail", idx)
+			should.Equal(body, string(res.body), "case %d fail", idx)
+			// the buffer is put back once the body is written
+			res.freeBody()
+			should.Nil(res.body, "case %d fail", idx)
+			should.Nil(res.free, "case %d fail", idx)
+			continue
+		}
+		actual, err := ioutil.ReadAll(res.stream)
+		should.NoError(err, "case %d fail", idx)
+		should.Equal(body, string(actual), "case %d fail", idx)
+		should.NoError(res.stream.Close())
+		should.True(done, "case %d fail", idx)
+		should.True(complete, "case %d fail", idx)
+		// temp files are removed once the response is sent
+		files, _ := filepath.Glob(filepath.Join(tmp, "*"))
+		should.Empty(files, "case %d fail", idx)
+	}
+}
@@ -2,21 +2,22 @@ package fastcgi
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/caibirdme/durian/log"
 	"github.com/caibirdme/durian/replace"
 	super "github.com/caibirdme/durian/server"
+	"github.com/caibirdme/durian/upstream"
 	"github.com/valyala/fasthttp"
 	"go.uber.org/zap"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 )
 
@@ -25,30 +26,57 @@ type Handler struct {
 	KeepConn    bool
 	ReadTimeout time.Duration
 	SendTimeout time.Duration
-	rule        *Rule
-	Next        fasthttp.RequestHandler
-	UpstreamGetter
+	// Buffering, BufferSize, MaxTempFileSize and TempPath decide how the response body is read, see readBody
+	Buffering       bool
+	BufferSize      int
+	MaxTempFileSize int
+	TempPath        string
+	rule            *Rule
+	Next            fasthttp.RequestHandler
+	Retry           upstream.RetryPolicy
+	group           *upstream.Group
+	balancer        upstream.Balancer
+	pools           map[*upstream.Peer]*connPool
+	// buffers keeps the buffers of response bodies, see readBody
+	buffers sync.Pool
 }
 
 func NewHandler(rule *Rule, cfg *Config, next fasthttp.RequestHandler) (*Handler, error) {
-	upg, err := NewRoundRobinUpstream(cfg.Upstream)
+	if cfg.Upstream == nil {
+		return nil, errNoUpstream
+	}
+	balancer, err := upstream.NewBalancer(cfg.Policy, cfg.PolicyArgs)
 	if err != nil {
 		return nil, err
 	}
 	h := &Handler{
-		UpstreamGetter: upg,
-		rule:           rule,
-		Next:           next,
-		ReadTimeout:    cfg.ReadTimeout,
-		SendTimeout:    cfg.SendTimeout,
-		KeepConn:       cfg.KeepConn,
-		Debug:          cfg.Debug,
+		group:           cfg.Upstream,
+		balancer:        balancer,
+		rule:            rule,
+		Next:            next,
+		ReadTimeout:     cfg.ReadTimeout,
+		SendTimeout:     cfg.SendTimeout,
+		KeepConn:        cfg.KeepConn,
+		Debug:           cfg.Debug,
+		Retry:           cfg.Retry,
+		Buffering:       cfg.Buffering,
+		BufferSize:      cfg.BufferSize,
+		MaxTempFileSize: cfg.MaxTempFileSize,
+		TempPath:        cfg.TempPath,
+		pools:           make(map[*upstream.Peer]*connPool),
+	}
+	maxIdle := cfg.MaxIdleConns
+	if !cfg.KeepConn {
+		maxIdle = 0
+	}
+	for _, peer := range cfg.Upstream.Peers() {
+		h.pools[peer] = newConnPool(peer.Network, peer.Addr, maxIdle, cfg.MaxConns, cfg.IdleTimeout, cfg.QueueTimeout)
 	}
 	return h, nil
 }
 
 func (h *Handler) Serve(reqCtx *fasthttp.RequestCtx) {
-	if !h.rule.location.Match(reqCtx.Path()) {
+	if !h.rule.location.Match(reqCtx) {
 		h.Next(reqCtx)
 		return
 	}
@@ -74,23 +102,220 @@ func (h *Handler) Serve(reqCtx *fasthttp.RequestCtx) {
 		}
 		return
 	}
-	network, addr := h.GetAddress()
-	fcgi, err := h.getFCGIClient(reqCtx, network, addr)
+	h.forward(reqCtx, env)
+}
+
+// dialError means the request hasn't been sent to backend
+type dialError struct {
+	err error
+}
+
+func (e *dialError) Error() string {
+	return e.err.Error()
+}
+
+func (e *dialError) Timeout() bool {
+	return isTimeout(e.err) || e.err == context.DeadlineExceeded
+}
+
+func (e *dialError) Temporary() bool {
+	return false
+}
+
+// clientError means the request body can't be read from client, it's not a failure of backend
+type clientError struct {
+	err error
+}
+
+func (e *clientError) Error() string {
+	return "read request body: " + e.err.Error()
+}
+
+// clientBody is the streamed request body
+type clientBody struct {
+	r io.Reader
+}
+
+func (b clientBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &clientError{err: err}
+	}
+	return n, err
+}
+
+// forward passes the request to backends until it succeeds or the retry policy gives up
+func (h *Handler) forward(reqCtx *fasthttp.RequestCtx, env map[string]string) {
+	start := time.Now()
+	var (
+		tried []*upstream.Peer
+		res   *fcgiResult
+		err   error
+	)
+	for {
+		peer := h.balancer.Pick(reqCtx, h.group.Untried(tried))
+		if peer == nil {
+			if len(tried) == 0 {
+				reqCtx.Error("[fcgi] no available backend", fasthttp.StatusBadGateway)
+			} else {
+				h.writeResult(reqCtx, res, err)
+			}
+			return
+		}
+		// the response of the last try is dropped
+		res.discard()
+		tried = append(tried, peer)
+		try := super.UpstreamTry{Addr: peer.String(), ConnectTime: -1, HeaderTime: -1}
+		res, err = h.roundTrip(reqCtx, env, peer, start, &try)
+		super.AddUpstreamTry(reqCtx, try)
+		if _, ok := err.(*clientError); ok || err == errStderrCaught {
+			// it's an error of the client or application rather than backend, so it's neither retried nor counted as a failure
+			h.writeResult(reqCtx, nil, err)
+			return
+		}
+		cond, failed := upstream.RetryOnError, true
+		if err != nil {
+			if h.Debug {
+				log.GetLogger().Error("[fcgi] request backend error",
+					zap.Error(err),
+					zap.String("network", peer.Network),
+					zap.String("addr", peer.Addr),
+				)
+			}
+			if isTimeout(err) {
+				cond = upstream.RetryOnTimeout
+			}
+		} else {
+			cond, failed = h.Retry.FailedStatus(res.status)
+		}
+		if !failed {
+			peer.MarkSuccess()
+			h.writeResult(reqCtx, res, nil)
+			return
+		}
+		peer.MarkFailure()
+		_, notSent := err.(*dialError)
+		// a streamed body can't be sent again
+		if !notSent && reqCtx.Request.IsBodyStream() || !h.Retry.ShouldRetry(cond, reqCtx.Method(), !notSent, len(tried), start) {
+			h.writeResult(reqCtx, res, err)
+			return
+		}
+	}
+}
+
+func (h *Handler) writeResult(reqCtx *fasthttp.RequestCtx, res *fcgiResult, err error) {
 	if err != nil {
-		reqCtx.Error("[fcgi] fail to connect backend", fasthttp.StatusBadGateway)
-		if h.Debug {
-			log.GetLogger().Error("[fcgi] fail to connect backend",
-				zap.Error(err),
-				zap.String("network", network),
-				zap.String("addr", addr),
-			)
+		if err == errStderrCaught {
+			reqCtx.Error("[fcgi] error caught from backend stderr", h.rule.CatchStderrStatus)
+		} else if _, ok := err.(*clientError); ok {
+			reqCtx.Error("[fcgi] fail to read request body", fasthttp.StatusBadRequest)
+		} else if isTimeout(err) {
+			reqCtx.Error("[fcgi] backend timeout", fasthttp.StatusGatewayTimeout)
+		} else if _, ok := err.(*dialError); ok {
+			reqCtx.Error("[fcgi] fail to connect backend", fasthttp.StatusBadGateway)
+		} else {
+			reqCtx.Error("[fcgi] request backend error", fasthttp.StatusBadGateway)
 		}
 		return
 	}
-	var resp *http.Response
+	h.writeResponse(reqCtx, res)
+}
+
+// roundTrip sends the request to peer and reads the response, peer is released once the response is read
+// roundTrip records the timing and status in try, the response time excludes streamed bodies
+func (h *Handler) roundTrip(reqCtx *fasthttp.RequestCtx, env map[string]string, peer *upstream.Peer, start time.Time, try *super.UpstreamTry) (*fcgiResult, error) {
+	begin := time.Now()
+	defer func() {
+		try.ResponseTime = time.Since(begin)
+	}()
+	peer.Acquire()
+	pool := h.pools[peer]
+	fcgi, reused, err := h.getFCGIClient(reqCtx, pool, false, start)
+	if err != nil {
+		peer.Release()
+		return nil, err
+	}
+	try.ConnectTime = time.Since(begin)
+	resp, err := h.do(reqCtx, env, fcgi)
+	if err != nil && reused && !fcgi.received && !isTimeout(err) && !reqCtx.Request.IsBodyStream() {
+		// the idle connection has been closed by backend, the request is never handled
+		pool.put(fcgi, false)
+		fcgi, _, err = h.getFCGIClient(reqCtx, pool, true, start)
+		if err != nil {
+			peer.Release()
+			return nil, err
+		}
+		try.ConnectTime = time.Since(begin)
+		resp, err = h.do(reqCtx, env, fcgi)
+	}
+	if err != nil {
+		pool.put(fcgi, false)
+		peer.Release()
+		return nil, err
+	}
+	try.HeaderTime = time.Since(begin)
+	var (
+		stderr   []byte
+		released bool
+	)
+	res, err := h.readBody(resp, func(complete bool) {
+		// stderr must be taken before the connection is used by other requests
+		if len(fcgi.Stderr()) > 0 {
+			stderr = append([]byte(nil), fcgi.Stderr()...)
+			h.logStderr(reqCtx, peer, stderr)
+		}
+		released = true
+		pool.put(fcgi, complete && fcgi.Reusable())
+		peer.Release()
+	})
+	if err != nil {
+		return nil, err
+	}
+	try.Status = res.status
+	if h.rule.CatchStderr == nil {
+		return res, nil
+	}
+	// the body is streamed from backend, only the stderr output before it can be checked
+	if !released {
+		stderr = fcgi.Stderr()
+	}
+	if h.rule.CatchStderr.Match(stderr) {
+		res.discard()
+		return nil, errStderrCaught
+	}
+	return res, nil
+}
+
+func (h *Handler) logStderr(reqCtx *fasthttp.RequestCtx, peer *upstream.Peer, stderr []byte) {
+	var scriptName string
+	if info, ok := reqCtx.UserValue(pathInfoKey).(pathInfo); ok {
+		scriptName = info.ScriptName
+	}
+	log.GetLogger().Warn("[fcgi] backend stderr",
+		zap.ByteString("request_id", super.GetRequestID(reqCtx)),
+		zap.String("script_name", scriptName),
+		zap.ByteString("uri", reqCtx.RequestURI()),
+		zap.String("addr", peer.Addr),
+		zap.ByteString("stderr", bytes.TrimSpace(stderr)),
+	)
+}
+
+// do sends the request and reads the response header
+func (h *Handler) do(reqCtx *fasthttp.RequestCtx, env map[string]string, fcgi *FCGIClient) (*http.Response, error) {
+	var (
+		resp *http.Response
+		err  error
+	)
+	// the body is sent to STDIN record by record, a streamed one as it arrives from client
+	var body io.Reader
+	if reqCtx.Request.IsBodyStream() {
+		body = clientBody{r: reqCtx.RequestBodyStream()}
+	} else {
+		body = bytes.NewReader(reqCtx.Request.Body())
+	}
 	switch string(reqCtx.Method()) {
 	case strGet:
-		resp, err = fcgi.Get(env, bytes.NewReader(reqCtx.Request.Body()), int64(reqCtx.Request.Header.ContentLength()))
+		resp, err = fcgi.Get(env, body, int64(reqCtx.Request.Header.ContentLength()))
 	case strHead:
 		resp, err = fcgi.Head(env)
 	case strOptions:
@@ -100,24 +325,17 @@ func (h *Handler) Serve(reqCtx *fasthttp.RequestCtx) {
 			env,
 			string(reqCtx.Method()),
 			string(reqCtx.Request.Header.ContentType()),
-			bytes.NewReader(reqCtx.Request.Body()),
+			body,
 			int64(reqCtx.Request.Header.ContentLength()),
 		)
 	}
 	if err != nil {
-		reqCtx.Error("[fcgi] request backend error", fasthttp.StatusBadGateway)
-		if h.Debug {
-			log.GetLogger().Error("[fcgi] fail to connect backend", zap.Error(err))
-		}
-		return
+		return nil, err
 	}
-	for k, v := range resp.Header {
-		reqCtx.Response.Header.Set(k, v[0])
+	if !fcgi.received {
+		return nil, errNoResponse
 	}
-	body, _ := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-	reqCtx.Write(body)
-	reqCtx.SetStatusCode(resp.StatusCode)
+	return resp, nil
 }
 
 const (
@@ -127,29 +345,44 @@ const (
 	pathInfoKey = "_path_info"
 )
 
-func (h *Handler) getFCGIClient(reqCtx *fasthttp.RequestCtx, network, addr string) (*FCGIClient, error) {
+func (h *Handler) getFCGIClient(reqCtx *fasthttp.RequestCtx, pool *connPool, fresh bool, start time.Time) (*FCGIClient, bool, error) {
 	ctx := super.GetStdCtx(reqCtx)
-	fcgi, err := DialContext(ctx, network, addr)
-	if err != nil {
-		return nil, err
+	if d := h.Retry.NextTimeout(0, start); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
 	}
-	err = fcgi.SetReadTimeout(h.ReadTimeout)
+	fcgi, reused, err := pool.get(ctx, fresh)
 	if err != nil {
-		return nil, err
+		return nil, false, &dialError{err: err}
+	}
+	fcgi.SetKeepAlive(h.KeepConn)
+	err = fcgi.SetReadTimeout(h.Retry.NextTimeout(h.ReadTimeout, start))
+	if err == nil {
+		err = fcgi.SetSendTimeout(h.Retry.NextTimeout(h.SendTimeout, start))
 	}
-	err = fcgi.SetSendTimeout(h.SendTimeout)
 	if err != nil {
-		return nil, err
+		pool.put(fcgi, false)
+		return nil, false, err
 	}
-	return fcgi, nil
+	return fcgi, reused, nil
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
 }
 
 type Rule struct {
-	location       super.LocationMatcher
-	Root           string
-	Index          string
-	SplitPathInfo  *regexp.Regexp
-	CatchStderr    string
+	location      super.LocationMatcher
+	Root          string
+	Index         string
+	SplitPathInfo *regexp.Regexp
+	// responses whose stderr output matches CatchStderr are replaced with CatchStderrStatus
+	CatchStderr       *regexp.Regexp
+	CatchStderrStatus int
+	// SendfileRoot enables X-Sendfile for the files under it
+	SendfileRoot   string
 	Params         map[string]string
 	ServerSoftware string
 	ServerName     string
@@ -162,8 +395,11 @@ type pathInfo struct {
 }
 
 var (
-	errSplitFail  = errors.New("fail to split path")
-	_headerPrefix = []byte("HTTP_")
+	errNoUpstream   = errors.New("upstream is required")
+	errNoResponse   = errors.New("connection closed without response")
+	errStderrCaught = errors.New("catch_stderr matched")
+	errSplitFail    = errors.New("fail to split path")
+	_headerPrefix   = []byte("HTTP_")
 )
 
 func addExt(path string, ext string) string {
@@ -323,25 +559,3 @@ func getAddr(addr net.Addr) (string, string, error) {
 	}
 	return "", "", fmt.Errorf("[fastcgi] fail to getAddr: %s", addr.String())
 }
-
-type UpstreamGetter interface {
-	GetAddress() (network string, address string)
-}
-
-type roundRobinUpstream struct {
-	backends []super.Backend
-	pos      int64
-}
-
-func (rr *roundRobinUpstream) GetAddress() (network string, address string) {
-	n := len(rr.backends)
-	new_pos := atomic.AddInt64(&rr.pos, 1)
-	idx := int(new_pos % int64(n))
-	return rr.backends[idx].Network, rr.backends[idx].Addr
-}
-
-func NewRoundRobinUpstream(upstream super.Upstream) (UpstreamGetter, error) {
-	return &roundRobinUpstream{
-		backends: upstream.Backends,
-	}, nil
-}
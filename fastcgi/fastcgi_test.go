@@ -1,10 +1,21 @@
 package fastcgi
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
 	"regexp"
 	"testing"
+	"time"
 
+	super "github.com/caibirdme/durian/server"
+	"github.com/caibirdme/durian/upstream"
 	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
 )
 
 func TestRule_newPathInfo(t *testing.T) {
@@ -87,3 +98,97 @@ func Test_convertHeader2EnvParam(t *testing.T) {
 		})
 	}
 }
+
+func TestForward_UpstreamTries(t *testing.T) {
+	should := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	defer ln.Close()
+	go serveFCGI(ln, "Status: 201\r\nContent-Type: text/plain\r\n\r\nok", "")
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	group := upstream.NewGroup(super.Upstream{Backends: []super.Backend{
+		{Network: "tcp", Addr: deadAddr, Weight: 1},
+		{Network: "tcp", Addr: ln.Addr().String(), Weight: 1},
+	}})
+	retry := upstream.DefaultRetryPolicy()
+	retry.Tries = 2
+	cfg := &Config{
+		Upstream:   group,
+		Policy:     upstream.PolicyRoundRobin,
+		Retry:      retry,
+		BufferSize: defaultBufferSize,
+	}
+	h, err := NewHandler(&Rule{}, cfg, nil)
+	should.NoError(err)
+	// round robin starts from the dead one
+	var ctx *fasthttp.RequestCtx
+	for i := 0; i < 2; i++ {
+		ctx = &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/index.php")
+		h.forward(ctx, map[string]string{})
+		if len(super.GetUpstreamTries(ctx)) == 2 {
+			break
+		}
+	}
+	should.Equal(fasthttp.StatusCreated, ctx.Response.StatusCode())
+	tries := super.GetUpstreamTries(ctx)
+	should.Len(tries, 2)
+	should.Equal(deadAddr+", "+ln.Addr().String(), string(super.AppendUpstreamVar(nil, ctx, super.VarUpstreamAddr)))
+	should.Equal("-, 201", string(super.AppendUpstreamVar(nil, ctx, super.VarUpstreamStatus)))
+	should.Equal(time.Duration(-1), tries[0].HeaderTime)
+	should.True(tries[1].ConnectTime >= 0 && tries[1].HeaderTime >= tries[1].ConnectTime)
+	should.True(tries[1].ResponseTime >= tries[1].HeaderTime)
+}
+
+type brokenReader struct{}
+
+func (brokenReader) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestForward_StreamedBody(t *testing.T) {
+	should := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	defer ln.Close()
+	go fcgi.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "%d %d", r.ContentLength, len(body))
+	}))
+	group := upstream.NewGroup(super.Upstream{Backends: []super.Backend{{Network: "tcp", Addr: ln.Addr().String(), Weight: 1}}})
+	h, err := NewHandler(&Rule{}, &Config{
+		Upstream:   group,
+		Policy:     upstream.PolicyRoundRobin,
+		Retry:      upstream.DefaultRetryPolicy(),
+		BufferSize: defaultBufferSize,
+	}, nil)
+	should.NoError(err)
+	env := func() map[string]string {
+		return map[string]string{"SERVER_PROTOCOL": "HTTP/1.1", "REQUEST_URI": "/upload.php"}
+	}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/upload.php")
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetBodyStream(bytes.NewReader(make([]byte, 1<<20)), 1<<20)
+	h.forward(ctx, env())
+	should.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+	should.Equal("1048576 1048576", string(ctx.Response.Body()))
+
+	// the client fails to send the whole body, which isn't a failure of backend
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/upload.php")
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetBodyStream(brokenReader{}, 1<<20)
+	h.forward(ctx, env())
+	should.Equal(fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	should.Zero(group.Primary[0].Failures())
+}
@@ -0,0 +1,152 @@
+package fastcgi
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxIdleConns = 16
+	defaultIdleTimeout  = 60 * time.Second
+	defaultQueueTimeout = 60 * time.Second
+)
+
+// errQueueTimeout means no connection is freed within queue_timeout, it's reported as a timeout
+var errQueueTimeout net.Error = queueTimeoutError{}
+
+type queueTimeoutError struct{}
+
+func (queueTimeoutError) Error() string   { return "no free connection to backend within queue_timeout" }
+func (queueTimeoutError) Timeout() bool   { return true }
+func (queueTimeoutError) Temporary() bool { return true }
+
+type idleConn struct {
+	c     *FCGIClient
+	since time.Time
+}
+
+// connPool keeps idle connections to a backend, connections are only put
+// back when the responder completed the request and kept the connection open
+type connPool struct {
+	network     string
+	addr        string
+	maxIdle     int
+	idleTimeout time.Duration
+	// open limits the number of open connections, nil means no limit
+	open chan struct{}
+	// queueTimeout limits the time waiting for a free connection when open is full, 0 means no limit
+	queueTimeout time.Duration
+
+	mu   sync.Mutex
+	idle []idleConn
+}
+
+func newConnPool(network, addr string, maxIdle, maxConns int, idleTimeout, queueTimeout time.Duration) *connPool {
+	p := &connPool{
+		network:      network,
+		addr:         addr,
+		maxIdle:      maxIdle,
+		idleTimeout:  idleTimeout,
+		queueTimeout: queueTimeout,
+	}
+	if maxConns > 0 {
+		p.open = make(chan struct{}, maxConns)
+	}
+	return p
+}
+
+// get returns an idle connection if there's any, otherwise dials a new one.
+// reused is true if the connection has been used by previous requests
+func (p *connPool) get(ctx context.Context, fresh bool) (c *FCGIClient, reused bool, err error) {
+	if !fresh {
+		if c = p.popIdle(); c != nil {
+			return c, true, nil
+		}
+	}
+	if err = p.acquire(ctx); err != nil {
+		return nil, false, err
+	}
+	c, err = DialContext(ctx, p.network, p.addr)
+	if err != nil {
+		p.release()
+		return nil, false, err
+	}
+	return c, false, nil
+}
+
+// acquire waits for a free slot of open connections, for queueTimeout at most
+func (p *connPool) acquire(ctx context.Context) error {
+	if p.open == nil {
+		return nil
+	}
+	select {
+	case p.open <- struct{}{}:
+		return nil
+	default:
+	}
+	var timeout <-chan time.Time
+	if p.queueTimeout > 0 {
+		timer := time.NewTimer(p.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p.open <- struct{}{}:
+		return nil
+	case <-timeout:
+		return errQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *connPool) popIdle() *FCGIClient {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.idle) > 0 {
+		last := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.idleTimeout > 0 && now.Sub(last.since) > p.idleTimeout {
+			p.closeConn(last.c)
+			continue
+		}
+		return last.c
+	}
+	return nil
+}
+
+// put gives c back to the pool, c is closed if it can't be reused or there're too many idle connections
+func (p *connPool) put(c *FCGIClient, reusable bool) {
+	if !reusable {
+		p.closeConn(c)
+		return
+	}
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// drop the expired ones, the oldest are at the front
+	i := 0
+	for ; i < len(p.idle) && p.idleTimeout > 0 && now.Sub(p.idle[i].since) > p.idleTimeout; i++ {
+		p.closeConn(p.idle[i].c)
+	}
+	p.idle = append(p.idle[:0], p.idle[i:]...)
+	if len(p.idle) >= p.maxIdle {
+		p.closeConn(c)
+		return
+	}
+	p.idle = append(p.idle, idleConn{c: c, since: now})
+}
+
+func (p *connPool) closeConn(c *FCGIClient) {
+	c.Close()
+	p.release()
+}
+
+func (p *connPool) release() {
+	if p.open != nil {
+		<-p.open
+	}
+}
@@ -0,0 +1,125 @@
+package fastcgi
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/caibirdme/durian/upstream"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+type countListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return conn, err
+}
+
+func startFCGIServer(t *testing.T) *countListener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l := &countListener{Listener: ln}
+	go fcgi.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	return l
+}
+
+func doPoolRequest(should *require.Assertions, p *connPool, keepAlive bool) bool {
+	c, reused, err := p.get(context.Background(), false)
+	should.NoError(err)
+	c.SetKeepAlive(keepAlive)
+	resp, err := c.Get(map[string]string{
+		"SERVER_PROTOCOL": "HTTP/1.1",
+		"REQUEST_URI":     "/",
+	}, nil, 0)
+	should.NoError(err)
+	body, err := ioutil.ReadAll(resp.Body)
+	should.NoError(err)
+	should.Equal("hello", string(body))
+	should.Equal(keepAlive, c.Reusable())
+	p.put(c, c.Reusable())
+	return reused
+}
+
+func TestConnPool(t *testing.T) {
+	should := require.New(t)
+	l := startFCGIServer(t)
+	defer l.Close()
+
+	p := newConnPool("tcp", l.Addr().String(), 1, 2, time.Minute, time.Second)
+	should.False(doPoolRequest(should, p, true))
+	for i := 0; i < 3; i++ {
+		should.True(doPoolRequest(should, p, true))
+	}
+	should.EqualValues(1, atomic.LoadInt32(&l.accepted))
+
+	// connections without FCGI_KEEP_CONN are closed by the responder
+	should.True(doPoolRequest(should, p, false))
+	should.False(doPoolRequest(should, p, false))
+	should.EqualValues(2, atomic.LoadInt32(&l.accepted))
+	should.Len(p.idle, 0)
+	should.Len(p.open, 0)
+}
+
+func TestConnPoolIdleTimeout(t *testing.T) {
+	should := require.New(t)
+	l := startFCGIServer(t)
+	defer l.Close()
+
+	p := newConnPool("tcp", l.Addr().String(), 1, 0, time.Millisecond, time.Second)
+	should.False(doPoolRequest(should, p, true))
+	time.Sleep(5 * time.Millisecond)
+	should.False(doPoolRequest(should, p, true))
+	should.EqualValues(2, atomic.LoadInt32(&l.accepted))
+}
+
+func TestConnPoolQueueTimeout(t *testing.T) {
+	should := require.New(t)
+	l := startFCGIServer(t)
+	defer l.Close()
+
+	group := upstream.NewGroup(super.Upstream{Backends: []super.Backend{{Network: "tcp", Addr: l.Addr().String(), Weight: 1}}})
+	h, err := NewHandler(&Rule{}, &Config{
+		Upstream:     group,
+		Policy:       upstream.PolicyRoundRobin,
+		Retry:        upstream.DefaultRetryPolicy(),
+		MaxConns:     1,
+		QueueTimeout: 50 * time.Millisecond,
+		BufferSize:   defaultBufferSize,
+	}, nil)
+	should.NoError(err)
+	env := map[string]string{"SERVER_PROTOCOL": "HTTP/1.1", "REQUEST_URI": "/"}
+	// the only connection is in use
+	pool := h.pools[group.Primary[0]]
+	c, _, err := pool.get(context.Background(), false)
+	should.NoError(err)
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/index.php")
+	start := time.Now()
+	h.forward(ctx, env)
+	should.Equal(fasthttp.StatusGatewayTimeout, ctx.Response.StatusCode())
+	should.True(time.Since(start) >= 50*time.Millisecond)
+	should.True(time.Since(start) < time.Second)
+
+	pool.put(c, false)
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/index.php")
+	h.forward(ctx, env)
+	should.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+}
@@ -156,10 +156,6 @@ func (rec *record) read(r io.Reader) (buf []byte, err error) {
 		err = errors.New("fcgi: invalid header version")
 		return
 	}
-	if rec.h.Type == EndRequest {
-		err = io.EOF
-		return
-	}
 	n := int(rec.h.ContentLength) + int(rec.h.PaddingLength)
 	if len(rec.rbuf) < n {
 		rec.rbuf = make([]byte, n)
@@ -168,7 +164,10 @@ func (rec *record) read(r io.Reader) (buf []byte, err error) {
 		return
 	}
 	buf = rec.rbuf[:int(rec.h.ContentLength)]
-
+	// the body of EndRequest is consumed as well, so that the connection can be reused
+	if rec.h.Type == EndRequest {
+		err = io.EOF
+	}
 	return
 }
 
@@ -184,6 +183,10 @@ type FCGIClient struct {
 	reqID       uint16
 	readTimeout time.Duration
 	sendTimeout time.Duration
+	// state of the current request
+	received       bool
+	ended          bool
+	protocolStatus uint8
 }
 
 // DialWithDialerContext connects to the fcgi responder at the specified network address, using custom net.Dialer
@@ -198,7 +201,6 @@ func DialWithDialerContext(ctx context.Context, network, address string, dialer
 	fcgi = &FCGIClient{
 		rwc:       conn,
 		keepAlive: false,
-		reqID:     1,
 	}
 
 	return
@@ -220,6 +222,21 @@ func (c *FCGIClient) Close() {
 	c.rwc.Close()
 }
 
+// SetKeepAlive asks the responder not to close the connection after the request by setting FCGI_KEEP_CONN
+func (c *FCGIClient) SetKeepAlive(keepAlive bool) {
+	c.keepAlive = keepAlive
+}
+
+// Stderr returns the FCGI_STDERR output of the current request read so far
+func (c *FCGIClient) Stderr() []byte {
+	return c.stderr.Bytes()
+}
+
+// Reusable reports whether the last request is completed and the responder keeps the connection open
+func (c *FCGIClient) Reusable() bool {
+	return c.keepAlive && c.ended && c.protocolStatus == RequestComplete
+}
+
 func (c *FCGIClient) writeRecord(recType uint8, content []byte) (err error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -234,6 +251,10 @@ func (c *FCGIClient) writeRecord(recType uint8, content []byte) (err error) {
 	if _, err := c.buf.Write(pad[:c.h.PaddingLength]); err != nil {
 		return err
 	}
+	// like reading, the timeout limits the interval between two writes, since the body may be streamed from client
+	if conn, ok := c.rwc.(net.Conn); ok && c.sendTimeout != 0 {
+		conn.SetWriteDeadline(time.Now().Add(c.sendTimeout))
+	}
 	_, err = c.rwc.Write(c.buf.Bytes())
 	return err
 }
@@ -356,10 +377,24 @@ func (w *streamReader) Read(p []byte) (n int, err error) {
 			for {
 				rec := &record{}
 				var buf []byte
+				if conn, ok := w.c.rwc.(net.Conn); ok && w.c.readTimeout != 0 {
+					conn.SetReadDeadline(time.Now().Add(w.c.readTimeout))
+				}
 				buf, err = rec.read(w.c.rwc)
+				if err == io.EOF && rec.h.Type == EndRequest && rec.h.ID == w.c.reqID {
+					w.c.received, w.c.ended = true, true
+					if len(buf) > 4 {
+						w.c.protocolStatus = buf[4]
+					}
+				}
 				if err != nil {
 					return
 				}
+				w.c.received = true
+				// records of other requests, such as the management records, are ignored
+				if rec.h.ID != w.c.reqID {
+					continue
+				}
 				// standard error output
 				if rec.h.Type == Stderr {
 					w.c.stderr.Write(buf)
@@ -384,6 +419,13 @@ func (w *streamReader) Read(p []byte) (n int, err error) {
 // Do made the request and returns a io.Reader that translates the data read
 // from fcgi responder out of fcgi packet before returning it.
 func (c *FCGIClient) Do(p map[string]string, req io.Reader) (r io.Reader, err error) {
+	// requests are issued one by one on a connection, but never reuse the ID of the last one
+	c.reqID++
+	if c.reqID == 0 {
+		c.reqID = 1
+	}
+	c.received, c.ended, c.protocolStatus = false, false, 0
+	c.stderr.Reset()
 	_flags := uint8(0)
 	if c.keepAlive {
 		_flags = FCGIKeepConn
@@ -400,9 +442,14 @@ func (c *FCGIClient) Do(p map[string]string, req io.Reader) (r io.Reader, err er
 
 	body := newWriter(c, Stdin)
 	if req != nil {
-		io.Copy(body, req)
+		// errors of the client stream are returned as well, the backend mustn't handle a truncated body
+		if _, err = io.Copy(body, req); err != nil {
+			return
+		}
+	}
+	if err = body.Close(); err != nil {
+		return
 	}
-	body.Close()
 
 	r = &streamReader{c: c}
 	return
@@ -563,7 +610,10 @@ func (c *FCGIClient) PostFile(p map[string]string, data url.Values, file map[str
 
 // SetReadTimeout sets the read timeout for future calls that read from the
 // fcgi responder. A zero value for t means no timeout will be set.
+// The deadline is extended before reading each record, so that it limits
+// the interval between two reads rather than the whole response.
 func (c *FCGIClient) SetReadTimeout(t time.Duration) error {
+	c.readTimeout = t
 	if conn, ok := c.rwc.(net.Conn); ok && t != 0 {
 		return conn.SetReadDeadline(time.Now().Add(t))
 	}
@@ -573,6 +623,7 @@ func (c *FCGIClient) SetReadTimeout(t time.Duration) error {
 // SetSendTimeout sets the read timeout for future calls that send data to
 // the fcgi responder. A zero value for t means no timeout will be set.
 func (c *FCGIClient) SetSendTimeout(t time.Duration) error {
+	c.sendTimeout = t
 	if conn, ok := c.rwc.(net.Conn); ok && t != 0 {
 		return conn.SetWriteDeadline(time.Now().Add(t))
 	}
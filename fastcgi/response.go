@@ -0,0 +1,152 @@
+package fastcgi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/caibirdme/durian/log"
+	super "github.com/caibirdme/durian/server"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+const (
+	headerStatus        = "Status"
+	headerLocation      = "Location"
+	headerSetCookie     = "Set-Cookie"
+	headerAccelRedirect = "X-Accel-Redirect"
+	headerSendfile      = "X-Sendfile"
+)
+
+var (
+	// headers of backend which are kept after X-Accel-Redirect and X-Sendfile, the same as nginx
+	keptRedirectHeaders = []string{headerSetCookie, "Content-Disposition", "Cache-Control", "Expires"}
+	// headers which are managed by fasthttp and can't be repeated
+	singleHeaders = map[string]bool{
+		"Content-Type":      true,
+		"Content-Length":    true,
+		"Server":            true,
+		"Connection":        true,
+		"Transfer-Encoding": true,
+		"Date":              true,
+	}
+	// CGI headers which are never sent to client
+	cgiHeaders = map[string]bool{
+		headerStatus:        true,
+		headerAccelRedirect: true,
+		headerSendfile:      true,
+	}
+	errSendfileForbidden = errors.New("X-Sendfile is outside of x_sendfile root")
+)
+
+// writeResponse translates the CGI response of backend into the http response, see RFC 3875 section 6
+func (h *Handler) writeResponse(reqCtx *fasthttp.RequestCtx, res *fcgiResult) {
+	if uri := res.header.Get(headerAccelRedirect); strings.HasPrefix(uri, "/") {
+		h.redirect(reqCtx, res, true, func() error {
+			return super.InternalRedirect(reqCtx, []byte(uri))
+		})
+		return
+	}
+	if path := res.header.Get(headerSendfile); path != "" && h.rule.SendfileRoot != "" {
+		h.redirect(reqCtx, res, true, func() error {
+			return h.sendfile(reqCtx, path)
+		})
+		return
+	}
+	location := res.header.Get(headerLocation)
+	_, hasStatus := res.header[headerStatus]
+	if !hasStatus && isLocalLocation(location) {
+		// local redirect response, the server serves the location instead and nothing of backend is kept
+		h.redirect(reqCtx, res, false, func() error {
+			return super.InternalRedirect(reqCtx, []byte(location))
+		})
+		return
+	}
+	status := res.status
+	if !hasStatus && location != "" {
+		// client redirect response
+		status = fasthttp.StatusFound
+	}
+	for k, v := range res.header {
+		if !cgiHeaders[k] {
+			addHeader(&reqCtx.Response.Header, k, v)
+		}
+	}
+	if res.stream != nil {
+		reqCtx.SetBodyStream(res.stream, res.size)
+	} else {
+		reqCtx.Write(res.body)
+		res.freeBody()
+	}
+	reqCtx.SetStatusCode(status)
+}
+
+// redirect drops the response of backend and lets do produce a new one
+func (h *Handler) redirect(reqCtx *fasthttp.RequestCtx, res *fcgiResult, keepHeaders bool, do func() error) {
+	res.discard()
+	if err := do(); err != nil {
+		log.GetLogger().Error("[fcgi] internal redirect error",
+			zap.Error(err),
+			zap.ByteString("request_id", super.GetRequestID(reqCtx)),
+			zap.ByteString("uri", reqCtx.RequestURI()),
+		)
+		reqCtx.Error("[fcgi] internal redirect error", fasthttp.StatusInternalServerError)
+		return
+	}
+	if !keepHeaders {
+		return
+	}
+	for _, k := range keptRedirectHeaders {
+		if v, ok := res.header[k]; ok {
+			addHeader(&reqCtx.Response.Header, k, v)
+		}
+	}
+}
+
+// sendfile serves the file under x_sendfile root directly
+func (h *Handler) sendfile(reqCtx *fasthttp.RequestCtx, path string) error {
+	path = filepath.Clean(path)
+	root := filepath.Clean(h.rule.SendfileRoot)
+	if !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return errSendfileForbidden
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		reqCtx.Response.Reset()
+		reqCtx.Error(fasthttp.StatusMessage(fasthttp.StatusNotFound), fasthttp.StatusNotFound)
+		return nil
+	}
+	// ServeFile rewrites the request uri to path, restore it for the log
+	uri := append([]byte(nil), reqCtx.Request.RequestURI()...)
+	reqCtx.Response.Reset()
+	fasthttp.ServeFile(reqCtx, path)
+	reqCtx.Request.SetRequestURIBytes(uri)
+	return nil
+}
+
+// addHeader keeps all the values of k
+func addHeader(dst *fasthttp.ResponseHeader, k string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	if k == headerSetCookie {
+		// fasthttp keeps every Set-Cookie which is set
+		for _, v := range values {
+			dst.Set(k, v)
+		}
+		return
+	}
+	dst.Set(k, values[0])
+	if singleHeaders[k] {
+		return
+	}
+	for _, v := range values[1:] {
+		dst.Add(k, v)
+	}
+}
+
+// isLocalLocation reports whether location is an absolute path rather than a URL
+func isLocalLocation(location string) bool {
+	return strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//")
+}
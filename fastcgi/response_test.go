@@ -0,0 +1,94 @@
+package fastcgi
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/caibirdme/durian/upstream"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func serveResponse(t *testing.T, rule *Rule, stdout string) *fasthttp.RequestCtx {
+	should := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	defer ln.Close()
+	go serveFCGI(ln, stdout, "")
+
+	h, err := NewHandler(rule, &Config{
+		Upstream: upstream.NewGroup(super.Upstream{Backends: []super.Backend{{Network: "tcp", Addr: ln.Addr().String(), Weight: 1}}}),
+		Policy:   upstream.PolicyRoundRobin,
+		Retry:    upstream.DefaultRetryPolicy(),
+	}, nil)
+	should.NoError(err)
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/index.php")
+	h.forward(ctx, map[string]string{})
+	return ctx
+}
+
+func TestWriteResponse_Headers(t *testing.T) {
+	should := require.New(t)
+	ctx := serveResponse(t, &Rule{}, "Status: 201 Created\r\n"+
+		"Set-Cookie: a=1; path=/\r\n"+
+		"Set-Cookie: a=2; path=/admin\r\n"+
+		"X-Foo: 1\r\n"+
+		"X-Foo: 2\r\n"+
+		"Content-Type: text/plain\r\n\r\nok")
+	should.Equal(fasthttp.StatusCreated, ctx.Response.StatusCode())
+	should.Equal("ok", string(ctx.Response.Body()))
+	should.Empty(ctx.Response.Header.Peek(headerStatus))
+	header := ctx.Response.Header.String()
+	should.Contains(header, "X-Foo: 1\r\n")
+	should.Contains(header, "X-Foo: 2\r\n")
+	should.Contains(header, "Set-Cookie: a=1; path=/\r\n")
+	should.Contains(header, "Set-Cookie: a=2; path=/admin\r\n")
+	should.Equal(1, strings.Count(header, "Content-Type"))
+}
+
+func TestWriteResponse_Redirect(t *testing.T) {
+	should := require.New(t)
+	// client redirect without Status
+	ctx := serveResponse(t, &Rule{}, "Location: http://example.com/login\r\n\r\n")
+	should.Equal(fasthttp.StatusFound, ctx.Response.StatusCode())
+	should.Equal("http://example.com/login", string(ctx.Response.Header.Peek(headerLocation)))
+
+	// local redirect needs the server, it fails without it
+	ctx = serveResponse(t, &Rule{}, "Location: /other.php\r\n\r\n")
+	should.Equal(fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+	ctx = serveResponse(t, &Rule{}, "Status: 301\r\nLocation: /other.php\r\n\r\n")
+	should.Equal(fasthttp.StatusMovedPermanently, ctx.Response.StatusCode())
+}
+
+func TestWriteResponse_Sendfile(t *testing.T) {
+	should := require.New(t)
+	root, err := ioutil.TempDir("", "fcgi-sendfile")
+	should.NoError(err)
+	defer os.RemoveAll(root)
+	file := filepath.Join(root, "report.txt")
+	should.NoError(ioutil.WriteFile(file, []byte("report"), 0644))
+
+	stdout := "X-Sendfile: " + file + "\r\nContent-Disposition: attachment\r\nX-Secret: 1\r\n\r\nignored"
+	ctx := serveResponse(t, &Rule{SendfileRoot: root}, stdout)
+	should.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+	should.Equal("report", string(ctx.Response.Body()))
+	should.Equal("attachment", string(ctx.Response.Header.Peek("Content-Disposition")))
+	should.Empty(ctx.Response.Header.Peek("X-Secret"))
+	should.Equal("/index.php", string(ctx.Request.RequestURI()))
+
+	// files outside of root are forbidden
+	stdout = "X-Sendfile: " + root + "/../etc/passwd\r\n\r\n"
+	ctx = serveResponse(t, &Rule{SendfileRoot: root}, stdout)
+	should.Equal(fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+
+	// X-Sendfile is ignored unless x_sendfile is set
+	ctx = serveResponse(t, &Rule{}, "X-Sendfile: "+file+"\r\n\r\nbody")
+	should.Equal("body", string(ctx.Response.Body()))
+	should.Empty(ctx.Response.Header.Peek(headerSendfile))
+}
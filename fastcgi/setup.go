@@ -3,9 +3,11 @@ package fastcgi
 import (
 	"github.com/caibirdme/durian/replace"
 	super "github.com/caibirdme/durian/server"
+	"github.com/caibirdme/durian/upstream"
 	"github.com/mholt/caddy"
 	"github.com/valyala/fasthttp"
 	"regexp"
+	"strconv"
 	"time"
 )
 
@@ -21,7 +23,21 @@ type Config struct {
 	KeepConn    bool
 	ReadTimeout time.Duration
 	SendTimeout time.Duration
-	Upstream    super.Upstream
+	Upstream    *upstream.Group
+	Policy      string
+	PolicyArgs  []string
+	Retry       upstream.RetryPolicy
+	// connection pool of each backend, only used with KeepConn
+	MaxIdleConns int
+	MaxConns     int
+	IdleTimeout  time.Duration
+	// QueueTimeout limits the time waiting for a free connection once MaxConns is reached
+	QueueTimeout time.Duration
+	// response buffering
+	Buffering       bool
+	BufferSize      int
+	MaxTempFileSize int
+	TempPath        string
 }
 
 func setup(c *caddy.Controller) error {
@@ -29,7 +45,10 @@ func setup(c *caddy.Controller) error {
 	if err != nil {
 		return err
 	}
-	super.GetConfig(c).AddMiddleware(func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	srvCfg := super.GetConfig(c)
+	// request bodies are sent to backend as they arrive
+	srvCfg.StreamRequestBody(rule.location)
+	srvCfg.AddMiddleware(func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 		var srv *Handler
 		srv, err = NewHandler(rule, cfg, next)
 		if err != nil {
@@ -47,13 +66,22 @@ func parseFcgiCfg(c *caddy.Controller) (*Config, *Rule, error) {
 		Params:    make(map[string]string),
 		templates: replace.NewVariablePlaceholder(),
 	}
-	cfg := Config{}
+	cfg := Config{
+		Policy:          upstream.PolicyRoundRobin,
+		Retry:           upstream.DefaultRetryPolicy(),
+		MaxIdleConns:    defaultMaxIdleConns,
+		IdleTimeout:     defaultIdleTimeout,
+		QueueTimeout:    defaultQueueTimeout,
+		Buffering:       true,
+		BufferSize:      defaultBufferSize,
+		MaxTempFileSize: defaultMaxTempFileSize,
+	}
 
 	firstLine := c.RemainingArgs()
 	if len(firstLine) == 0 {
 		return nil, nil, c.ArgErr()
 	}
-	location, err := super.NewLocationMatcher(firstLine)
+	location, err := super.NewMatcher(c, firstLine)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -82,7 +110,23 @@ func parseFcgiCfg(c *caddy.Controller) (*Config, *Rule, error) {
 			}
 		case "catch_stderr":
 			if len(list) > 1 {
-				rule.CatchStderr = list[1]
+				re, err := regexp.Compile(list[1])
+				if err != nil {
+					return nil, nil, c.Errf("invalid catch_stderr pattern: %s", err)
+				}
+				rule.CatchStderr = re
+				rule.CatchStderrStatus = fasthttp.StatusBadGateway
+			}
+			if len(list) > 2 {
+				status, err := strconv.Atoi(list[2])
+				if err != nil || status < 400 || status > 599 {
+					return nil, nil, c.Errf("catch_stderr status should be 4xx or 5xx but %s", list[2])
+				}
+				rule.CatchStderrStatus = status
+			}
+		case "x_sendfile":
+			if len(list) > 1 {
+				rule.SendfileRoot = list[1]
 			}
 		case "server_software":
 			if len(list) > 1 {
@@ -112,19 +156,77 @@ func parseFcgiCfg(c *caddy.Controller) (*Config, *Rule, error) {
 				}
 				cfg.SendTimeout = d
 			}
+		case "max_idle_conns", "max_conns":
+			if len(list) > 1 {
+				n, err := strconv.Atoi(list[1])
+				if err != nil || n < 0 {
+					return nil, nil, c.Errf("value of %s must be a non-negative int but %s", list[0], list[1])
+				}
+				if list[0] == "max_idle_conns" {
+					cfg.MaxIdleConns = n
+				} else {
+					cfg.MaxConns = n
+				}
+			}
+		case "idle_timeout":
+			if len(list) > 1 {
+				d, err := time.ParseDuration(list[1])
+				if err != nil {
+					return nil, nil, c.Errf("idle_timeout isn't a duration: %s", err)
+				}
+				cfg.IdleTimeout = d
+			}
+		case "queue_timeout":
+			if len(list) > 1 {
+				d, err := time.ParseDuration(list[1])
+				if err != nil || d <= 0 {
+					return nil, nil, c.Errf("queue_timeout should be a positive duration but %s", list[1])
+				}
+				cfg.QueueTimeout = d
+			}
+		case "buffering":
+			if len(list) > 1 {
+				switch list[1] {
+				case "on":
+					cfg.Buffering = true
+				case "off":
+					cfg.Buffering = false
+				default:
+					return nil, nil, c.Errf("buffering should be on or off but %s", list[1])
+				}
+			}
+		case "buffer_size", "max_temp_file_size":
+			if len(list) > 1 {
+				size, err := super.ParseSize(list[1])
+				if err != nil || size < 0 {
+					return nil, nil, c.Errf("invalid %s %s", list[0], list[1])
+				}
+				if list[0] == "buffer_size" {
+					cfg.BufferSize = size
+				} else {
+					cfg.MaxTempFileSize = size
+				}
+			}
+		case "temp_path":
+			if len(list) > 1 {
+				cfg.TempPath = list[1]
+			}
 		case "upstream":
 			if len(list) > 1 {
 				name := list[1]
-				if v := c.Get(super.UpstreamKey); v != nil {
-					m := v.(map[string]super.Upstream)
-					if u, ok := m[name]; ok {
-						cfg.Upstream = u
-					} else {
-						return nil, nil, c.Errf("invalid upstream name %s", name)
-					}
-				} else {
+				g, ok := upstream.GetGroup(c, name)
+				if !ok {
 					return nil, nil, c.Errf("invalid upstream name %s", name)
 				}
+				cfg.Upstream = g
+			}
+		case "policy":
+			if len(list) > 1 {
+				cfg.Policy = list[1]
+				cfg.PolicyArgs = list[2:]
+				if _, err := upstream.NewBalancer(cfg.Policy, cfg.PolicyArgs); err != nil {
+					return nil, nil, c.Err(err.Error())
+				}
 			}
 		case "fcgi_param":
 			if len(list) > 2 {
@@ -132,10 +234,14 @@ func parseFcgiCfg(c *caddy.Controller) (*Config, *Rule, error) {
 				rule.templates.SetTmpl(list[2])
 			}
 		case "except":
-			excludeLocation, err = super.NewLocationMatcher(c.RemainingArgs())
+			excludeLocation, err = super.NewMatcher(c, c.RemainingArgs())
 			if err != nil {
 				return nil, nil, c.Err(err.Error())
 			}
+		default:
+			if _, err := cfg.Retry.ParseOption(list[0], list[1:]); err != nil {
+				return nil, nil, c.Err(err.Error())
+			}
 		}
 	}
 	if rule.Root == "" {
@@ -157,6 +263,9 @@ func parseFcgiCfg(c *caddy.Controller) (*Config, *Rule, error) {
 	} else {
 		rule.location = location
 	}
+	if cfg.Upstream == nil {
+		return nil, nil, c.Err("upstream is required")
+	}
 	rule.includeScriptParam()
 	return &cfg, &rule, nil
 }
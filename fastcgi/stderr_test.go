@@ -0,0 +1,93 @@
+package fastcgi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"regexp"
+	"testing"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/caibirdme/durian/upstream"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+// serveFCGI is a fake responder answering every request with stdout and stderr
+func serveFCGI(ln net.Listener, stdout, stderr string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				var h header
+				if err := binary.Read(conn, binary.BigEndian, &h); err != nil {
+					return
+				}
+				content := make([]byte, int(h.ContentLength)+int(h.PaddingLength))
+				if _, err := io.ReadFull(conn, content); err != nil {
+					return
+				}
+				if h.Type != Stdin || h.ContentLength != 0 {
+					continue
+				}
+				var buf bytes.Buffer
+				for _, rec := range []struct {
+					t       uint8
+					content string
+				}{{Stdout, stdout}, {Stderr, stderr}, {Stdout, ""}, {EndRequest, "\x00\x00\x00\x00\x00\x00\x00\x00"}} {
+					var rh header
+					rh.init(rec.t, h.ID, len(rec.content))
+					binary.Write(&buf, binary.BigEndian, rh)
+					buf.WriteString(rec.content)
+					buf.Write(pad[:rh.PaddingLength])
+				}
+				conn.Write(buf.Bytes())
+			}
+		}(conn)
+	}
+}
+
+func TestCatchStderr(t *testing.T) {
+	should := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	defer ln.Close()
+	go serveFCGI(ln, "Content-Type: text/html\r\n\r\n<html>half", "PHP Fatal error: oops")
+
+	group := upstream.NewGroup(super.Upstream{Backends: []super.Backend{{Network: "tcp", Addr: ln.Addr().String(), Weight: 1}}})
+	cfg := &Config{
+		Upstream:     group,
+		Policy:       upstream.PolicyRoundRobin,
+		Retry:        upstream.DefaultRetryPolicy(),
+		KeepConn:     true,
+		MaxIdleConns: 1,
+		BufferSize:   defaultBufferSize,
+	}
+	rule := &Rule{}
+	h, err := NewHandler(rule, cfg, nil)
+	should.NoError(err)
+
+	serve := func() *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/index.php")
+		h.forward(ctx, map[string]string{})
+		return ctx
+	}
+	ctx := serve()
+	should.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+	should.Equal("<html>half", string(ctx.Response.Body()))
+
+	rule.CatchStderr = regexp.MustCompile("PHP Fatal error")
+	rule.CatchStderrStatus = fasthttp.StatusInternalServerError
+	ctx = serve()
+	should.Equal(fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+	should.NotContains(string(ctx.Response.Body()), "half")
+	// an application error doesn't make the backend unavailable
+	should.True(group.Primary[0].Available())
+	should.Len(h.pools[group.Primary[0]].idle, 1)
+}
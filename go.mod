@@ -3,11 +3,12 @@ module github.com/caibirdme/durian
 go 1.12
 
 require (
+	github.com/andybalholm/brotli v1.0.2
 	github.com/buaazp/fasthttprouter v0.1.1
 	github.com/mholt/caddy v1.0.0
 	github.com/pkg/errors v0.8.1
 	github.com/stretchr/testify v1.3.0
-	github.com/valyala/fasthttp v1.2.0
+	github.com/valyala/fasthttp v1.32.0
 	github.com/valyala/fasttemplate v1.0.1
 	go.uber.org/atomic v1.4.0 // indirect
 	go.uber.org/multierr v1.1.0 // indirect
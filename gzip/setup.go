@@ -20,9 +20,6 @@ func setup(c *caddy.Controller) error {
 	if err != nil {
 		return err
 	}
-	if cfg == nilConfig {
-		return nil
-	}
 	if cfg.Level == 0 {
 		return nil
 	}
@@ -30,16 +27,31 @@ func setup(c *caddy.Controller) error {
 	if srvCfg == nil {
 		return nil
 	}
-	srvCfg.Gzip.Open = true
-	srvCfg.Gzip.Level = cfg.Level
+	srvCfg.Gzip = super.GzipConfig{
+		Open:      true,
+		Level:     cfg.Level,
+		MinLength: cfg.MinLength,
+		Types:     cfg.Types,
+		Encodings: cfg.Encodings,
+	}
 	return nil
 }
 
 type GzipConfig struct {
-	Level int
+	Level     int
+	MinLength int
+	Types     []string
+	Encodings []string
 }
 
-var nilConfig = GzipConfig{}
+var (
+	nilConfig          = GzipConfig{}
+	supportedEncodings = map[string]bool{
+		super.EncodingGzip:    true,
+		super.EncodingDeflate: true,
+		super.EncodingBrotli:  true,
+	}
+)
 
 const (
 	defaultGzipLevel = 6
@@ -51,6 +63,7 @@ func parseGzip(c *caddy.Controller) (GzipConfig, error) {
 	// if only gzip and no block, use defaultGzipLevel
 	cfg := GzipConfig{Level: defaultGzipLevel}
 
+	var err error
 	for c.NextBlock() {
 		kind := c.Val()
 		switch strings.ToLower(kind) {
@@ -63,6 +76,34 @@ func parseGzip(c *caddy.Controller) (GzipConfig, error) {
 				return nilConfig, c.Err(err.Error())
 			}
 			cfg.Level = level
+		case "min_length":
+			if !c.NextArg() {
+				return nilConfig, c.ArgErr()
+			}
+			cfg.MinLength, err = strconv.Atoi(c.Val())
+			if err != nil {
+				return nilConfig, c.Err(err.Error())
+			}
+		case "types":
+			types := c.RemainingArgs()
+			if len(types) == 0 {
+				return nilConfig, c.ArgErr()
+			}
+			for _, t := range types {
+				cfg.Types = append(cfg.Types, strings.ToLower(t))
+			}
+		case "encodings":
+			encodings := c.RemainingArgs()
+			if len(encodings) == 0 {
+				return nilConfig, c.ArgErr()
+			}
+			for _, enc := range encodings {
+				enc = strings.ToLower(enc)
+				if !supportedEncodings[enc] {
+					return nilConfig, c.Errf("unsupported encoding %s", enc)
+				}
+				cfg.Encodings = append(cfg.Encodings, enc)
+			}
 		}
 	}
 	return cfg, nil
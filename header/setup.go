@@ -21,7 +21,7 @@ func setup(c *caddy.Controller) error {
 	h := NewHeaderSetter(cfg.Headers)
 	super.GetConfig(c).AddMiddleware(func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 		return func(ctx *fasthttp.RequestCtx) {
-			if cfg.location.Match(ctx.Path()) {
+			if cfg.location.Match(ctx) {
 				if err := h.Set(ctx); err != nil {
 					// todo: log
 				}
@@ -52,7 +52,7 @@ func parseHeader(c *caddy.Controller) (*HeaderConfig, error) {
 	}
 	var cfg HeaderConfig
 	var err error
-	cfg.location, err = super.NewLocationMatcher(firstLine)
+	cfg.location, err = super.NewMatcher(c, firstLine)
 	if err != nil {
 		return nil, err
 	}
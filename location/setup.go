@@ -0,0 +1,129 @@
+package location
+
+import (
+	"sort"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyfile"
+)
+
+func init() {
+	caddy.RegisterPlugin(super.DirectiveLocation, caddy.Plugin{
+		ServerType: super.FastHTTPServerType,
+		Action:     setup,
+	})
+}
+
+// nestable are the directives allowed inside location blocks, others configure the whole server
+var nestable = map[string]struct{}{
+	super.DirectiveProxy:          {},
+	super.DirectiveFastCgi:        {},
+	super.DirectiveStatic:         {},
+	super.DirectiveResponse:       {},
+	super.DirectiveHeader:         {},
+	super.DirectiveStatus:         {},
+	super.DirectiveRewrite:        {},
+	super.DirectiveUpstreamStatus: {},
+}
+
+// block is a location block and the directives inside it
+type block struct {
+	modifier   string
+	pattern    string
+	directives []directive
+}
+
+type directive struct {
+	name   string
+	tokens []caddyfile.Token
+}
+
+func setup(c *caddy.Controller) error {
+	// all the location blocks of a server block are dispensed together
+	for c.Next() {
+		b, err := parseBlock(c)
+		if err != nil {
+			return err
+		}
+		lo, err := super.NewLocation(b.modifier, b.pattern)
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		err = super.GetConfig(c).AddLocation(lo, func() error {
+			for _, d := range b.directives {
+				action, err := caddy.DirectiveAction(super.FastHTTPServerType, d.name)
+				if err != nil {
+					return err
+				}
+				// the directive sees the same instance and server as the location
+				sub := *c
+				sub.Dispenser = caddyfile.NewDispenserTokens(c.File(), d.tokens)
+				if err := action(&sub); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return c.Err(err.Error())
+		}
+	}
+	return nil
+}
+
+//	location [=|^~|~|~*] pattern {
+//	    header / X-Frame-Options DENY
+//	    proxy / {
+//	        upstream backend
+//	    }
+//	}
+func parseBlock(c *caddy.Controller) (*block, error) {
+	b := &block{}
+	switch args := c.RemainingArgs(); len(args) {
+	case 1:
+		b.pattern = args[0]
+	case 2:
+		b.modifier, b.pattern = args[0], args[1]
+	default:
+		return nil, c.ArgErr()
+	}
+	if !c.Next() || c.Val() != "{" {
+		return nil, c.SyntaxErr("{")
+	}
+	// a directive starts at a new line outside the blocks of other directives
+	depth, line := 0, c.Line()
+	for {
+		if !c.Next() {
+			return nil, c.EOFErr()
+		}
+		val := c.Val()
+		if depth == 0 && val == "}" {
+			break
+		}
+		if depth == 0 && (c.Line() > line || len(b.directives) == 0) {
+			if _, ok := nestable[val]; !ok {
+				return nil, c.Errf("directive %s isn't allowed in location", val)
+			}
+			b.directives = append(b.directives, directive{name: val})
+		}
+		d := &b.directives[len(b.directives)-1]
+		d.tokens = append(d.tokens, caddyfile.Token{File: c.File(), Line: c.Line(), Text: val})
+		switch val {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		}
+		line = c.Line()
+	}
+	// directives run in the same order as they're outside location blocks
+	order := make(map[string]int)
+	for i, name := range caddy.ValidDirectives(super.FastHTTPServerType) {
+		order[name] = i
+	}
+	sort.SliceStable(b.directives, func(i, j int) bool {
+		return order[b.directives[i].name] < order[b.directives[j].name]
+	})
+	return b, nil
+}
@@ -0,0 +1,50 @@
+package location
+
+import (
+	"testing"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/mholt/caddy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBlock(t *testing.T) {
+	should := require.New(t)
+	c := caddy.NewTestController(super.FastHTTPServerType, `location ~* \.php$ {
+		proxy / {
+			upstream {
+				127.0.0.1:9000
+			}
+			timeout 1s
+		}
+		header / X-Frame-Options DENY
+	}`)
+	c.Next()
+	b, err := parseBlock(c)
+	should.NoError(err)
+	should.Equal(super.LocationRegexCaseless, b.modifier)
+	should.Equal(`\.php$`, b.pattern)
+	should.Len(b.directives, 2)
+	// directives are sorted like they're outside location blocks, so that header wraps proxy
+	should.Equal(super.DirectiveProxy, b.directives[0].name)
+	should.Equal(super.DirectiveHeader, b.directives[1].name)
+	should.Len(b.directives[1].tokens, 4)
+	texts := make([]string, 0, len(b.directives[0].tokens))
+	for _, tk := range b.directives[0].tokens {
+		texts = append(texts, tk.Text)
+	}
+	should.Equal([]string{"proxy", "/", "{", "upstream", "{", "127.0.0.1:9000", "}", "timeout", "1s", "}"}, texts)
+
+	for _, input := range []string{
+		"location",
+		"location = /a /b {\n}",
+		"location /a",
+		"location /a {\n gzip\n}",
+		"location /a {\n status / 403\n",
+	} {
+		c := caddy.NewTestController(super.FastHTTPServerType, input)
+		c.Next()
+		_, err := parseBlock(c)
+		should.Error(err, input)
+	}
+}
@@ -0,0 +1,166 @@
+package log
+
+import (
+	"bufio"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultQueueSize     = 4096
+	defaultFlushInterval = time.Second
+
+	OverflowBlock = "block"
+	OverflowDrop  = "drop"
+)
+
+var droppedEntries uint64
+
+// DroppedEntries returns the number of log entries dropped because the queue was full or the remote sink was unavailable
+func DroppedEntries() uint64 {
+	return atomic.LoadUint64(&droppedEntries)
+}
+
+// asyncWriter writes entries to out in background through a bounded queue,
+// out is flushed when the buffer is full, every flushInterval and on Sync
+type asyncWriter struct {
+	out           zapcore.WriteSyncer
+	buf           *bufio.Writer
+	queue         chan []byte
+	drop          bool
+	flushInterval time.Duration
+
+	syncReq chan chan struct{}
+	stopCh  chan struct{}
+	done    chan struct{}
+	// stopMu makes queueing and stopping exclusive, so nothing is queued after the final drain
+	stopMu sync.RWMutex
+	// mu serializes the synchronous writes after the writer is stopped
+	mu      sync.Mutex
+	stopped int32
+}
+
+func newAsyncWriter(out zapcore.WriteSyncer, bufSize, queueSize int, flushInterval time.Duration, overflow string) *asyncWriter {
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	w := &asyncWriter{
+		out:           out,
+		buf:           bufio.NewWriterSize(out, bufSize),
+		queue:         make(chan []byte, queueSize),
+		drop:          overflow == OverflowDrop,
+		flushInterval: flushInterval,
+		syncReq:       make(chan chan struct{}),
+		stopCh:        make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write never blocks the request if the policy is drop, p is copied since zap reuses it
+func (w *asyncWriter) Write(p []byte) (int, error) {
+	w.stopMu.RLock()
+	if atomic.LoadInt32(&w.stopped) == 1 {
+		w.stopMu.RUnlock()
+		return w.writeStopped(p)
+	}
+	entry := make([]byte, len(p))
+	copy(entry, p)
+	if w.drop {
+		select {
+		case w.queue <- entry:
+		default:
+			atomic.AddUint64(&droppedEntries, 1)
+		}
+	} else {
+		// Stop waits for it, and the queue is consumed until then
+		w.queue <- entry
+	}
+	w.stopMu.RUnlock()
+	return len(p), nil
+}
+
+// writeStopped writes the entries logged during shutdown directly, once the queue is drained
+func (w *asyncWriter) writeStopped(p []byte) (int, error) {
+	<-w.done
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Write(p)
+}
+
+// Sync waits until all the queued entries are written to out
+func (w *asyncWriter) Sync() error {
+	if atomic.LoadInt32(&w.stopped) == 1 {
+		<-w.done
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.out.Sync()
+	}
+	ack := make(chan struct{})
+	select {
+	case w.syncReq <- ack:
+		<-ack
+	case <-w.done:
+	}
+	return w.out.Sync()
+}
+
+// Stop drains the queue and flushes everything, the writer falls back to synchronous write after it
+func (w *asyncWriter) Stop() error {
+	w.stopMu.Lock()
+	stopped := atomic.SwapInt32(&w.stopped, 1) == 1
+	w.stopMu.Unlock()
+	if stopped {
+		return nil
+	}
+	close(w.stopCh)
+	<-w.done
+	return w.out.Sync()
+}
+
+func (w *asyncWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry := <-w.queue:
+			w.write(entry)
+		case <-ticker.C:
+			w.buf.Flush()
+		case ack := <-w.syncReq:
+			w.drain()
+			close(ack)
+		case <-w.stopCh:
+			w.drain()
+			return
+		}
+	}
+}
+
+func (w *asyncWriter) drain() {
+	for {
+		select {
+		case entry := <-w.queue:
+			w.write(entry)
+		default:
+			w.buf.Flush()
+			return
+		}
+	}
+}
+
+// write never splits an entry into two writes of out, which network sinks rely on to frame messages
+func (w *asyncWriter) write(entry []byte) {
+	if len(entry) > w.buf.Available() && w.buf.Buffered() > 0 {
+		w.buf.Flush()
+	}
+	w.buf.Write(entry)
+}
@@ -0,0 +1,127 @@
+package log
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memSink is a WriteSyncer which blocks writing until it's released
+type memSink struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	release chan struct{}
+}
+
+func (s *memSink) Write(p []byte) (int, error) {
+	if s.release != nil {
+		<-s.release
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *memSink) Sync() error { return nil }
+
+func (s *memSink) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+func TestAsyncWriter_Block(t *testing.T) {
+	should := require.New(t)
+	sink := &memSink{}
+	w := newAsyncWriter(sink, 64, 4, time.Hour, OverflowBlock)
+	var expect bytes.Buffer
+	for i := 0; i < 100; i++ {
+		line := []byte(strconv.Itoa(i) + "\n")
+		expect.Write(line)
+		_, err := w.Write(line)
+		should.NoError(err)
+	}
+	should.NoError(w.Sync())
+	should.Equal(expect.String(), sink.String())
+
+	_, err := w.Write([]byte("last\n"))
+	should.NoError(err)
+	should.NoError(w.Stop())
+	should.Equal(expect.String()+"last\n", sink.String())
+	// writes after stop are synchronous
+	w.Write([]byte("after\n"))
+	should.Equal(expect.String()+"last\nafter\n", sink.String())
+}
+
+func TestAsyncWriter_Drop(t *testing.T) {
+	should := require.New(t)
+	sink := &memSink{release: make(chan struct{})}
+	// the buffer is smaller than an entry, so the background goroutine is stuck on the sink
+	w := newAsyncWriter(sink, 1, 2, time.Hour, OverflowDrop)
+	before := DroppedEntries()
+	for i := 0; i < 10; i++ {
+		w.Write([]byte("entry\n"))
+	}
+	should.True(DroppedEntries()-before >= 7)
+	close(sink.release)
+	should.NoError(w.Stop())
+	lines := bytes.Count([]byte(sink.String()), []byte("\n"))
+	should.Equal(10, lines+int(DroppedEntries()-before))
+}
+
+// lineSink records every write
+type lineSink struct {
+	mu     sync.Mutex
+	writes []string
+}
+
+func (s *lineSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes = append(s.writes, string(p))
+	return len(p), nil
+}
+
+func (s *lineSink) Sync() error { return nil }
+
+func TestAsyncWriter_WholeEntries(t *testing.T) {
+	should := require.New(t)
+	sink := &lineSink{}
+	w := newAsyncWriter(sink, 16, 4, time.Hour, OverflowBlock)
+	for _, entry := range []string{"0123456789\n", "0123456789\n", "a long entry over the buffer\n", "end\n"} {
+		_, err := w.Write([]byte(entry))
+		should.NoError(err)
+	}
+	should.NoError(w.Stop())
+	// network sinks frame each write, so an entry is never split
+	should.Equal([]string{"0123456789\n", "0123456789\n", "a long entry over the buffer\n", "end\n"}, sink.writes)
+}
+
+func TestAsyncWriter_StopWhileWriting(t *testing.T) {
+	should := require.New(t)
+	for _, overflow := range []string{OverflowBlock, OverflowDrop} {
+		sink := &memSink{}
+		w := newAsyncWriter(sink, 64, 4, time.Hour, overflow)
+		before := DroppedEntries()
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					w.Write([]byte("entry\n"))
+				}
+			}()
+		}
+		time.Sleep(time.Millisecond)
+		should.NoError(w.Stop())
+		wg.Wait()
+		// every entry is either written or counted as dropped, none is left in the queue
+		lines := bytes.Count([]byte(sink.String()), []byte("\n"))
+		should.Equal(8*200, lines+int(DroppedEntries()-before), overflow)
+	}
+}
@@ -0,0 +1,225 @@
+package log
+
+import (
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/caibirdme/durian/replace"
+	super "github.com/caibirdme/durian/server"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	FormatJSON     = "json"
+	FormatLogfmt   = "logfmt"
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	FormatTemplate = "template"
+
+	clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+var linePool = buffer.NewPool()
+
+// newEntityWriter returns the access log writer of cfg.FormatKind
+func newEntityWriter(out zapcore.WriteSyncer, cfg LogConfig) (EntityWriter, error) {
+	r := newRedactor(cfg.Redact)
+	switch cfg.FormatKind {
+	case FormatLogfmt:
+		writers, err := lookupWriters(cfg.Format, r)
+		if err != nil {
+			return nil, err
+		}
+		return &logfmtWriter{out: out, writers: writers}, nil
+	case FormatCommon:
+		return &clfWriter{out: out, redactor: r}, nil
+	case FormatCombined:
+		return &clfWriter{out: out, redactor: r, combined: true}, nil
+	case FormatTemplate:
+		return newTemplateWriter(out, cfg.Template, r), nil
+	default:
+		return newFormatWriter(out, cfg.Format, r)
+	}
+}
+
+// clfWriter writes the Common Log Format, or the combined one which nginx and apache use by default:
+//	$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"
+type clfWriter struct {
+	out      zapcore.WriteSyncer
+	redactor *redactor
+	combined bool
+}
+
+func (w *clfWriter) Write(ctx *fasthttp.RequestCtx) {
+	line := linePool.Get()
+	line.AppendString(ctx.RemoteIP().String())
+	line.AppendString(" - - [")
+	line.AppendString(ctx.Time().Format(clfTimeFormat))
+	line.AppendString(`] "`)
+	appendEscaped(line, ctx.Method())
+	line.AppendByte(' ')
+	appendEscaped(line, w.redactor.requestURI(super.OriginalRequestURI(ctx)))
+	if ctx.Request.Header.IsHTTP11() {
+		line.AppendString(` HTTP/1.1" `)
+	} else {
+		line.AppendString(` HTTP/1.0" `)
+	}
+	line.AppendInt(int64(ctx.Response.StatusCode()))
+	line.AppendByte(' ')
+	line.AppendInt(int64(bodySize(ctx)))
+	if w.combined {
+		line.AppendString(` "`)
+		appendEscaped(line, ctx.Referer())
+		line.AppendString(`" "`)
+		appendEscaped(line, ctx.Request.Header.UserAgent())
+		line.AppendByte('"')
+	}
+	line.AppendByte('\n')
+	writeLine(w.out, line)
+}
+
+// appendEscaped writes "-" for empty values and escapes quotes, backslashes and
+// unprintable characters like nginx does, so that each entry is still a line
+func appendEscaped(line *buffer.Buffer, b []byte) {
+	if len(b) == 0 {
+		line.AppendByte('-')
+		return
+	}
+	const hex = "0123456789ABCDEF"
+	for _, c := range b {
+		if c == '"' || c == '\\' || c < ' ' || c > '~' {
+			line.AppendString(`\x`)
+			line.AppendByte(hex[c>>4])
+			line.AppendByte(hex[c&0xf])
+			continue
+		}
+		line.AppendByte(c)
+	}
+}
+
+// logfmtWriter writes key=value pairs of the format entries, see https://brandur.org/logfmt
+type logfmtWriter struct {
+	out     zapcore.WriteSyncer
+	writers []partialWriter
+}
+
+func (w *logfmtWriter) Write(ctx *fasthttp.RequestCtx) {
+	line := linePool.Get()
+	for i, h := range w.writers {
+		if i > 0 {
+			line.AppendByte(' ')
+		}
+		f := h(ctx)
+		line.AppendString(f.Key)
+		line.AppendByte('=')
+		appendLogfmtValue(line, f)
+	}
+	line.AppendByte('\n')
+	writeLine(w.out, line)
+}
+
+func appendLogfmtValue(line *buffer.Buffer, f zapcore.Field) {
+	switch f.Type {
+	case zapcore.StringType:
+		appendLogfmtString(line, f.String)
+	case zapcore.ByteStringType:
+		appendLogfmtString(line, string(f.Interface.([]byte)))
+	case zapcore.Int64Type:
+		line.AppendInt(f.Integer)
+	case zapcore.Uint64Type:
+		line.AppendUint(uint64(f.Integer))
+	case zapcore.DurationType:
+		line.AppendString(time.Duration(f.Integer).String())
+	case zapcore.TimeType:
+		t := time.Unix(0, f.Integer)
+		if loc, ok := f.Interface.(*time.Location); ok {
+			t = t.In(loc)
+		}
+		line.AppendString(t.Format(time.RFC3339))
+	default:
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+		if s, ok := enc.Fields[f.Key].(string); ok {
+			appendLogfmtString(line, s)
+		} else {
+			appendLogfmtString(line, "-")
+		}
+	}
+}
+
+// appendLogfmtString quotes s if it's empty or contains spaces, quotes or '='
+func appendLogfmtString(line *buffer.Buffer, s string) {
+	quote := len(s) == 0
+	for i := 0; i < len(s) && !quote; i++ {
+		quote = s[i] <= ' ' || s[i] == '"' || s[i] == '=' || s[i] == '\\' || s[i] >= utf8.RuneSelf
+	}
+	if !quote {
+		line.AppendString(s)
+		return
+	}
+	line.AppendString(strconv.Quote(s))
+}
+
+// templateWriter writes entries by a template like `{remote} - {method} {uri} {status} {latency_ms}`,
+// all the placeholders are supported and unknown ones are written as "-"
+type templateWriter struct {
+	out       zapcore.WriteSyncer
+	tmpl      string
+	templates *replace.VariablePlaceholder
+	redactor  *redactor
+}
+
+func newTemplateWriter(out zapcore.WriteSyncer, tmpl string, r *redactor) *templateWriter {
+	w := &templateWriter{out: out, tmpl: tmpl, templates: replace.NewVariablePlaceholder(), redactor: r}
+	w.templates.SetTmpl(tmpl)
+	return w
+}
+
+func (w *templateWriter) Write(ctx *fasthttp.RequestCtx) {
+	tmpl, _ := w.templates.GetTmpl(w.tmpl)
+	line := linePool.Get()
+	_, err := tmpl.ExecuteFunc(line, func(out io.Writer, tag string) (int, error) {
+		switch {
+		case w.redactor.maskTag(tag):
+			return io.WriteString(out, redactedMask)
+		case tag == "query":
+			return out.Write(w.redactor.queryString(super.OriginalQueryString(ctx)))
+		case tag == "uri":
+			return out.Write(w.redactor.requestURI(super.OriginalRequestURI(ctx)))
+		}
+		n, err := replace.ReplaceVariable(ctx, out, tag)
+		if err == replace.ErrNotBuiltin {
+			return out.Write([]byte("-"))
+		}
+		return n, err
+	})
+	if err != nil {
+		line.Free()
+		GetLogger().Error("[log] execute access log template error: " + err.Error())
+		return
+	}
+	line.AppendByte('\n')
+	writeLine(w.out, line)
+}
+
+func writeLine(out zapcore.WriteSyncer, line *buffer.Buffer) {
+	if _, err := out.Write(line.Bytes()); err != nil {
+		GetLogger().Error("[log] write access log error: " + err.Error())
+	}
+	line.Free()
+}
+
+// bodySize doesn't read streamed bodies, whose size is only known from Content-Length
+func bodySize(ctx *fasthttp.RequestCtx) int {
+	if ctx.Response.IsBodyStream() {
+		if n := ctx.Response.Header.ContentLength(); n > 0 {
+			return n
+		}
+		return 0
+	}
+	return len(ctx.Response.Body())
+}
@@ -0,0 +1,139 @@
+package log
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyfile"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func newLogCtx() *fasthttp.RequestCtx {
+	var ctx fasthttp.RequestCtx
+	var req fasthttp.Request
+	req.Header.SetMethod("GET")
+	req.SetRequestURI("/foo?a=1")
+	req.Header.SetReferer(`http://a.com/"x"`)
+	req.Header.SetUserAgent("curl/7.54.0")
+	ctx.Init(&req, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}, nil)
+	ctx.SetStatusCode(fasthttp.StatusNotFound)
+	ctx.SetBodyString("not found")
+	return &ctx
+}
+
+func TestFormat(t *testing.T) {
+	should := require.New(t)
+	ctx := newLogCtx()
+	when := ctx.Time().Format(clfTimeFormat)
+	testCases := []struct {
+		cfg    LogConfig
+		expect string
+	}{
+		{
+			cfg:    LogConfig{FormatKind: FormatCommon},
+			expect: `10.0.0.1 - - [` + when + `] "GET /foo?a=1 HTTP/1.1" 404 9` + "\n",
+		},
+		{
+			cfg:    LogConfig{FormatKind: FormatCombined},
+			expect: `10.0.0.1 - - [` + when + `] "GET /foo?a=1 HTTP/1.1" 404 9 "http://a.com/\x22x\x22" "curl/7.54.0"` + "\n",
+		},
+		{
+			cfg:    LogConfig{FormatKind: FormatLogfmt, Format: []string{entryKeyMethod, entryKeyStatusCode, entryReferer, entryKeyQueryString}},
+			expect: `method=GET status=404 referer="http://a.com/\"x\"" query_string="a=1"` + "\n",
+		},
+		{
+			cfg:    LogConfig{FormatKind: FormatTemplate, Template: "{remote} - {method} {uri} {status} {unknown}"},
+			expect: "10.0.0.1 - GET /foo?a=1 404 -\n",
+		},
+		{
+			cfg:    LogConfig{FormatKind: FormatJSON, Format: []string{entryKeyMethod, entryKeyBodyBytesSent}},
+			expect: `{"method":"GET","body_bytes_sent":9}` + "\n",
+		},
+	}
+	for idx, tc := range testCases {
+		sink := &memSink{}
+		w, err := newEntityWriter(sink, tc.cfg)
+		should.NoError(err, "case %d", idx)
+		w.Write(ctx)
+		should.Equal(tc.expect, sink.String(), "case %d", idx)
+	}
+}
+
+func TestFormat_InternalRedirect(t *testing.T) {
+	should := require.New(t)
+	c := caddy.NewTestController(super.FastHTTPServerType, "")
+	sblocks, err := caddyfile.Parse("Testfile", strings.NewReader(":8080 {\n}"), nil)
+	should.NoError(err)
+	_, err = c.Context().InspectServerBlocks("Testfile", sblocks)
+	should.NoError(err)
+	c.Key = ":8080"
+	super.GetConfig(c).AddMiddleware(func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			if string(ctx.Path()) == "/app.php" {
+				should.NoError(super.InternalRedirect(ctx, []byte("/static/a.txt?v=1")))
+				return
+			}
+			ctx.SetBodyString("ok")
+		}
+	})
+	servers, err := c.Context().MakeServers()
+	should.NoError(err)
+	ctx := newLogCtx()
+	ctx.Request.SetRequestURI("/app.php?id=1")
+	servers[0].(*super.FastServer).Handler(ctx)
+	should.Equal("/static/a.txt?v=1", string(ctx.RequestURI()))
+
+	// all the formats log what the client asked for
+	when := ctx.Time().Format(clfTimeFormat)
+	for idx, tc := range []struct {
+		cfg    LogConfig
+		expect string
+	}{
+		{
+			cfg:    LogConfig{FormatKind: FormatCommon},
+			expect: `10.0.0.1 - - [` + when + `] "GET /app.php?id=1 HTTP/1.1" 200 2` + "\n",
+		},
+		{
+			cfg:    LogConfig{FormatKind: FormatJSON, Format: []string{entryKeyRequestURI, entryKeyQueryString}},
+			expect: `{"request_uri":"/app.php","query_string":"id=1"}` + "\n",
+		},
+		{
+			cfg:    LogConfig{FormatKind: FormatTemplate, Template: "{uri} {query}"},
+			expect: "/app.php?id=1 id=1\n",
+		},
+	} {
+		sink := &memSink{}
+		w, err := newEntityWriter(sink, tc.cfg)
+		should.NoError(err, "case %d", idx)
+		w.Write(ctx)
+		should.Equal(tc.expect, sink.String(), "case %d", idx)
+	}
+}
+
+func TestFormat_StreamedBody(t *testing.T) {
+	should := require.New(t)
+	ctx := newLogCtx()
+	body := &trackReader{}
+	ctx.SetBodyStream(body, 9)
+	sink := &memSink{}
+	w, err := newEntityWriter(sink, LogConfig{FormatKind: FormatLogfmt, Format: []string{entryKeyBodyBytesSent}})
+	should.NoError(err)
+	w.Write(ctx)
+	should.Equal("body_bytes_sent=9\n", sink.String())
+	// the stream isn't consumed by the logger
+	should.False(body.read)
+}
+
+type trackReader struct {
+	read bool
+}
+
+func (r *trackReader) Read(p []byte) (int, error) {
+	r.read = true
+	return 0, io.EOF
+}
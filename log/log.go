@@ -15,72 +15,129 @@ import (
 const (
 	defaultAccessLogName = "access.log"
 	defaultErrLogName    = "error.log"
+	// defaultRemoteBuffer is the buffer of network sinks without the buffer option
+	defaultRemoteBuffer = 16 * 1024
 )
 
 var (
 	globalLogger *zap.Logger
+	// nopLogger is used if log directive isn't configured
+	nopLogger = zap.NewNop()
 )
 
 // GetLogger returns global logger
 // user should only log error info via this logger
 func GetLogger() *zap.Logger {
+	if globalLogger == nil {
+		return nopLogger
+	}
 	return globalLogger
 }
 
-func NewLogger(cfg LogConfig) (EntityWriter, func() error, error) {
-	err := confirmPath(&cfg)
-	if err != nil {
-		return nil, nil, err
+// confirmPath appends defaultName to path if it's a directory, and makes sure the directory exists.
+// Sinks other than files are returned as they are
+func confirmPath(path, defaultName string) (string, error) {
+	if !isFile(path) {
+		return path, nil
+	}
+	if filepath.Ext(path) == "" {
+		path = filepath.Join(path, defaultName)
 	}
-	globalLogger, err = newZapLogger(cfg)
+	return path, os.MkdirAll(filepath.Dir(path), 0755)
+}
+
+// openAccessOutput returns the output of access log and a function which flushes and closes it
+func openAccessOutput(cfg LogConfig) (zapcore.WriteSyncer, func() error, error) {
+	path, err := confirmPath(cfg.AccessPath, defaultAccessLogName)
 	if err != nil {
 		return nil, nil, err
 	}
-	fwriter, err := newFormatWriter(globalLogger, cfg.Format)
+	out, err := openSink(path, cfg, severityInfo)
 	if err != nil {
 		return nil, nil, err
 	}
-	return fwriter, globalLogger.Sync, nil
+	if isRemote(path) {
+		async := newRemoteWriter(out, cfg)
+		return async, func() error {
+			err := async.Stop()
+			out.Close()
+			return err
+		}, nil
+	}
+	if cfg.Buffer <= 0 {
+		return out, func() error {
+			err := out.Sync()
+			out.Close()
+			return err
+		}, nil
+	}
+	async := newAsyncWriter(out, cfg.Buffer, cfg.Queue, cfg.Flush, cfg.Overflow)
+	return async, func() error {
+		err := async.Stop()
+		out.Close()
+		return err
+	}, nil
 }
 
-func confirmPath(cfg *LogConfig) error {
-	if filepath.Ext(cfg.AccessPath) == "" {
-		cfg.AccessPath = filepath.Join(cfg.AccessPath, defaultAccessLogName)
+// openErrLogger returns the error logger and a function which flushes and closes it
+func openErrLogger(cfg LogConfig) (*zap.Logger, func() error, error) {
+	path, err := confirmPath(cfg.ErrPath, defaultErrLogName)
+	if err != nil {
+		return nil, nil, err
 	}
-	dir := filepath.Dir(cfg.AccessPath)
-	err := os.MkdirAll(dir, 0755)
+	errOut, err := openSink(path, cfg, severityErr)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	if filepath.Ext(cfg.ErrPath) == "" {
-		cfg.ErrPath = filepath.Join(cfg.ErrPath, defaultErrLogName)
+	if isRemote(path) {
+		async := newRemoteWriter(errOut, cfg)
+		errLogger := newErrLogger(cfg.ErrLog, async)
+		return errLogger, func() error {
+			err := async.Stop()
+			errOut.Close()
+			return err
+		}, nil
 	}
-	dir = filepath.Dir(cfg.ErrPath)
-	err = os.MkdirAll(dir, 0755)
-	if err != nil {
+	errLogger := newErrLogger(cfg.ErrLog, errOut)
+	return errLogger, func() error {
+		err := errLogger.Sync()
+		errOut.Close()
 		return err
+	}, nil
+}
+
+// newRemoteWriter writes to the network sink out in background even without buffer, so that a stalled remote
+// never blocks the requests. Entries are dropped once the queue is full unless overflow is block
+func newRemoteWriter(out zapcore.WriteSyncer, cfg LogConfig) *asyncWriter {
+	bufSize := cfg.Buffer
+	if bufSize <= 0 {
+		bufSize = defaultRemoteBuffer
+	}
+	overflow := cfg.Overflow
+	if overflow == "" {
+		overflow = OverflowDrop
 	}
-	return nil
+	return newAsyncWriter(out, bufSize, cfg.Queue, cfg.Flush, overflow)
 }
 
-func newZapLogger(cfg LogConfig) (*zap.Logger, error) {
-	zapCfg := zap.Config{
-		Level:       zap.NewAtomicLevel(),
-		Development: false,
-		Sampling: &zap.SamplingConfig{
-			Initial:    100,
-			Thereafter: 100,
-		},
-		Encoding:         "json",
-		EncoderConfig:    newProductionEncoderConfig(),
-		OutputPaths:      []string{cfg.AccessPath},
-		ErrorOutputPaths: []string{cfg.ErrPath},
+func newErrLogger(cfg ErrLogConfig, out zapcore.WriteSyncer) *zap.Logger {
+	var enc zapcore.Encoder
+	if cfg.Encoding == EncodingConsole {
+		enc = zapcore.NewConsoleEncoder(newErrEncoderConfig())
+	} else {
+		enc = zapcore.NewJSONEncoder(newErrEncoderConfig())
+	}
+	core := zapcore.NewCore(enc, out, cfg.Level)
+	if cfg.SampleInitial > 0 {
+		core = zapcore.NewSampler(core, time.Second, cfg.SampleInitial, cfg.SampleThereafter)
 	}
-	return zapCfg.Build()
+	return zap.New(core, zap.ErrorOutput(out), zap.AddStacktrace(zapcore.ErrorLevel))
 }
 
-func newProductionEncoderConfig() zapcore.EncoderConfig {
+func newErrEncoderConfig() zapcore.EncoderConfig {
 	return zapcore.EncoderConfig{
+		TimeKey:        "time",
+		LevelKey:       "level",
 		MessageKey:     "msg",
 		StacktraceKey:  "stacktrace",
 		LineEnding:     zapcore.DefaultLineEnding,
@@ -91,12 +148,23 @@ func newProductionEncoderConfig() zapcore.EncoderConfig {
 	}
 }
 
+// newAccessEncoderConfig only keeps the fields, since access entries have no message or level
+func newAccessEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+	}
+}
+
 type EntityWriter interface {
 	Write(ctx *fasthttp.RequestCtx)
 }
 
+// formatWriter writes every entry to out without sampling
 type formatWriter struct {
-	l       *zap.Logger
+	enc     zapcore.Encoder
+	out     zapcore.WriteSyncer
 	writers []partialWriter
 }
 
@@ -112,23 +180,42 @@ func (f *formatWriter) Write(ctx *fasthttp.RequestCtx) {
 	for _, h := range f.writers {
 		fields = append(fields, h(ctx))
 	}
-	f.l.Info("", fields...)
+	buf, err := f.enc.EncodeEntry(zapcore.Entry{}, fields)
 	bufPool.Put(fields)
+	if err != nil {
+		GetLogger().Error("[log] encode access log error: " + err.Error())
+		return
+	}
+	writeLine(f.out, buf)
+}
+
+func newFormatWriter(out zapcore.WriteSyncer, format []string, r *redactor) (*formatWriter, error) {
+	writers, err := lookupWriters(format, r)
+	if err != nil {
+		return nil, err
+	}
+	return &formatWriter{
+		enc:     zapcore.NewJSONEncoder(newAccessEncoderConfig()),
+		out:     out,
+		writers: writers,
+	}, nil
 }
 
-func newFormatWriter(l *zap.Logger, format []string) (*formatWriter, error) {
+// lookupWriters returns the writers of the entries, the ones writing headers and bodies are provided by r
+func lookupWriters(format []string, r *redactor) ([]partialWriter, error) {
+	overrides := r.overrideWriters()
 	writers := make([]partialWriter, 0, len(format))
 	for _, val := range format {
-		h, ok := writerDict[val]
+		h, ok := overrides[val]
+		if !ok {
+			h, ok = writerDict[val]
+		}
 		if !ok {
 			return nil, fmt.Errorf("[log] %s not supported", val)
 		}
 		writers = append(writers, h)
 	}
-	return &formatWriter{
-		l:       l,
-		writers: writers,
-	}, nil
+	return writers, nil
 }
 
 //  caller's responsibility to ensure entity isn't nil
@@ -158,38 +245,41 @@ const (
 
 var (
 	writerDict = map[string]partialWriter{
-		entryKeyHost:               hostWriter,
-		entryRequestID:             requestIDWriter,
-		entryStartTime:             startTimeWriter,
-		entryReferer:               refererWriter,
-		entryKeyBytesSent:          bytesSentWriter,
-		entryKeyBodyBytesSent:      bodyBytesSentWriter,
-		entryKeyConnectionRequests: connectionRequestsWriter,
-		entryKeyProcessTime:        processTimeWriter,
-		entryKeyRequestLength:      requestLengthWriter,
-		entryKeyStatusCode:         statusCodeWriter,
-		entryKeyUA:                 userAgentWriter,
-		entryKeyRemoteAddr:         remoteAddrWriter,
-		entryKeyRequestURI:         requestURIWriter,
-		entryKeyQueryString:        queryStringWriter,
-		entryKeyRequestBody:        requestBodyWriter,
-		entryKeyRequestHeader:      requestHeaderWriter,
-		entryKeyMethod:             methodWriter,
-		entryKeyResponseBody:       responseBodyWriter,
-		entryKeyResponseHeader:     responseHeaderWriter,
+		entryKeyHost:                  hostWriter,
+		entryRequestID:                requestIDWriter,
+		entryStartTime:                startTimeWriter,
+		entryReferer:                  refererWriter,
+		entryKeyBytesSent:             bytesSentWriter,
+		entryKeyBodyBytesSent:         bodyBytesSentWriter,
+		entryKeyConnectionRequests:    connectionRequestsWriter,
+		entryKeyProcessTime:           processTimeWriter,
+		entryKeyRequestLength:         requestLengthWriter,
+		entryKeyStatusCode:            statusCodeWriter,
+		entryKeyUA:                    userAgentWriter,
+		entryKeyRemoteAddr:            remoteAddrWriter,
+		entryKeyRequestURI:            requestURIWriter,
+		entryKeyMethod:                methodWriter,
+		super.VarUpstreamAddr:         upstreamWriter(super.VarUpstreamAddr),
+		super.VarUpstreamStatus:       upstreamWriter(super.VarUpstreamStatus),
+		super.VarUpstreamConnectTime:  upstreamWriter(super.VarUpstreamConnectTime),
+		super.VarUpstreamHeaderTime:   upstreamWriter(super.VarUpstreamHeaderTime),
+		super.VarUpstreamResponseTime: upstreamWriter(super.VarUpstreamResponseTime),
 	}
 )
 
+// upstreamWriter writes the upstream variable of all the tries, see super.AppendUpstreamVar
+func upstreamWriter(name string) partialWriter {
+	return func(ctx *fasthttp.RequestCtx) zapcore.Field {
+		return zap.ByteString(name, super.AppendUpstreamVar(nil, ctx, name))
+	}
+}
+
 func hostWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
 	return zap.ByteString(entryKeyHost, ctx.Host())
 }
 
 func requestIDWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
-	headerKey := ctx.UserValue(super.RequestIDHeaderName)
-	var reqID []byte
-	if headerKey != nil {
-		reqID = ctx.Request.Header.Peek(headerKey.(string))
-	}
+	reqID := super.GetRequestID(ctx)
 	if len(reqID) == 0 {
 		return zap.String(entryRequestID, "-")
 	} else {
@@ -205,32 +295,12 @@ func startTimeWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
 	return zap.Time(entryStartTime, ctx.Time())
 }
 
-func responseHeaderWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
-	return zap.ByteString(entryKeyResponseHeader, ctx.Response.Header.Header())
-}
-
-func responseBodyWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
-	return zap.ByteString(entryKeyResponseBody, ctx.Response.Body())
-}
-
 func methodWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
 	return zap.ByteString(entryKeyMethod, ctx.Method())
 }
 
-func requestHeaderWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
-	return zap.ByteString(entryKeyRequestHeader, ctx.Request.Header.Header())
-}
-
-func requestBodyWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
-	return zap.ByteString(entryKeyRequestBody, ctx.Request.Body())
-}
-
-func queryStringWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
-	return zap.ByteString(entryKeyQueryString, ctx.Request.URI().QueryString())
-}
-
 func requestURIWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
-	return zap.ByteString(entryKeyRequestURI, ctx.Request.URI().Path())
+	return zap.ByteString(entryKeyRequestURI, super.OriginalPath(ctx))
 }
 
 func remoteAddrWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
@@ -242,12 +312,12 @@ func userAgentWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
 }
 
 func bytesSentWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
-	count := len(ctx.Response.Header.Header()) + len(ctx.Response.Body())
+	count := len(ctx.Response.Header.Header()) + bodySize(ctx)
 	return zap.Int(entryKeyBytesSent, count)
 }
 
 func bodyBytesSentWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
-	return zap.Int(entryKeyBodyBytesSent, len(ctx.Response.Body()))
+	return zap.Int(entryKeyBodyBytesSent, bodySize(ctx))
 }
 
 func connectionRequestsWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
@@ -259,7 +329,13 @@ func processTimeWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
 }
 
 func requestLengthWriter(ctx *fasthttp.RequestCtx) zapcore.Field {
-	count := len(ctx.Request.Header.Header()) + len(ctx.Request.Body())
+	count := len(ctx.Request.Header.Header())
+	// the streamed body has been consumed by handlers
+	if ctx.Request.IsBodyStream() {
+		count += ctx.Request.Header.ContentLength()
+	} else {
+		count += len(ctx.Request.Body())
+	}
 	return zap.Int(entryKeyRequestLength, count)
 }
 
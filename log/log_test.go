@@ -0,0 +1,58 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestOpenLogs_Unsampled(t *testing.T) {
+	should := require.New(t)
+	dir, err := ioutil.TempDir("", "durian-log")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+	cfg := LogConfig{
+		AccessPath: dir,
+		ErrPath:    dir,
+		Format:     []string{entryKeyMethod, entryKeyStatusCode},
+		ErrLog: ErrLogConfig{
+			Level:            zapcore.WarnLevel,
+			SampleInitial:    1,
+			SampleThereafter: 1000,
+		},
+	}
+	accessOut, closeAccess, err := openAccessOutput(cfg)
+	should.NoError(err)
+	w, err := newEntityWriter(accessOut, cfg)
+	should.NoError(err)
+	errLogger, closeErr, err := openErrLogger(cfg)
+	should.NoError(err)
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod("GET")
+	for i := 0; i < 1000; i++ {
+		w.Write(&ctx)
+	}
+	errLogger.Info("ignored")
+	errLogger.Warn("sampled")
+	errLogger.Warn("sampled")
+	should.NoError(closeAccess())
+	should.NoError(closeErr())
+
+	access, err := ioutil.ReadFile(filepath.Join(dir, defaultAccessLogName))
+	should.NoError(err)
+	lines := bytes.Split(bytes.TrimSpace(access), []byte("\n"))
+	should.Len(lines, 1000)
+	should.Equal(`{"method":"GET","status":200}`, string(lines[0]))
+
+	errLog, err := ioutil.ReadFile(filepath.Join(dir, defaultErrLogName))
+	should.NoError(err)
+	should.Equal(1, bytes.Count(errLog, []byte("\n")))
+	should.Contains(string(errLog), `"level":"warn"`)
+	should.Contains(string(errLog), `"msg":"sampled"`)
+}
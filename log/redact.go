@@ -0,0 +1,263 @@
+package log
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	redactedMask    = "***"
+	truncatedMarker = "...[truncated]"
+)
+
+// Redaction masks sensitive data and limits the bodies written to the access log
+type Redaction struct {
+	// Headers, Cookies and Query are masked by name, header names are case insensitive
+	Headers []string
+	Cookies []string
+	Query   []string
+	// MaxBody truncates the logged bodies to MaxBody bytes, 0 means no limit
+	MaxBody int
+	// BodyTypes only logs the bodies of the media types, such as application/json or text/*
+	BodyTypes []string
+}
+
+// redactor is the compiled Redaction
+type redactor struct {
+	headers   map[string]struct{}
+	cookies   map[string]struct{}
+	query     map[string]struct{}
+	maxBody   int
+	bodyTypes []string
+}
+
+func newRedactor(r Redaction) *redactor {
+	toSet := func(names []string, lower bool) map[string]struct{} {
+		if len(names) == 0 {
+			return nil
+		}
+		set := make(map[string]struct{}, len(names))
+		for _, name := range names {
+			if lower {
+				name = strings.ToLower(name)
+			}
+			set[name] = struct{}{}
+		}
+		return set
+	}
+	bodyTypes := make([]string, 0, len(r.BodyTypes))
+	for _, t := range r.BodyTypes {
+		bodyTypes = append(bodyTypes, strings.ToLower(t))
+	}
+	return &redactor{
+		headers:   toSet(r.Headers, true),
+		cookies:   toSet(r.Cookies, false),
+		query:     toSet(r.Query, false),
+		maxBody:   r.MaxBody,
+		bodyTypes: bodyTypes,
+	}
+}
+
+// overrideWriters replaces the writers of writerDict which may write sensitive data
+func (r *redactor) overrideWriters() map[string]partialWriter {
+	return map[string]partialWriter{
+		entryKeyRequestHeader: func(ctx *fasthttp.RequestCtx) zapcore.Field {
+			return zap.ByteString(entryKeyRequestHeader, r.requestHeader(ctx))
+		},
+		entryKeyResponseHeader: func(ctx *fasthttp.RequestCtx) zapcore.Field {
+			return zap.ByteString(entryKeyResponseHeader, r.responseHeader(ctx))
+		},
+		entryKeyQueryString: func(ctx *fasthttp.RequestCtx) zapcore.Field {
+			return zap.ByteString(entryKeyQueryString, r.queryString(super.OriginalQueryString(ctx)))
+		},
+		entryKeyRequestBody: func(ctx *fasthttp.RequestCtx) zapcore.Field {
+			return zap.ByteString(entryKeyRequestBody, r.requestBody(ctx))
+		},
+		entryKeyResponseBody: func(ctx *fasthttp.RequestCtx) zapcore.Field {
+			return zap.ByteString(entryKeyResponseBody, r.responseBody(ctx))
+		},
+	}
+}
+
+func (r *redactor) requestHeader(ctx *fasthttp.RequestCtx) []byte {
+	if r.headers == nil && r.cookies == nil && r.query == nil {
+		return ctx.Request.Header.Header()
+	}
+	var buf bytes.Buffer
+	buf.Write(ctx.Method())
+	buf.WriteByte(' ')
+	buf.Write(r.requestURI(super.OriginalRequestURI(ctx)))
+	if ctx.Request.Header.IsHTTP11() {
+		buf.WriteString(" HTTP/1.1\r\n")
+	} else {
+		buf.WriteString(" HTTP/1.0\r\n")
+	}
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		if _, ok := r.headers[strings.ToLower(string(key))]; ok {
+			value = []byte(redactedMask)
+		} else if r.cookies != nil && string(key) == "Cookie" {
+			value = r.cookieHeader(value)
+		}
+		writeHeaderLine(&buf, key, value)
+	})
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+func (r *redactor) responseHeader(ctx *fasthttp.RequestCtx) []byte {
+	raw := ctx.Response.Header.Header()
+	if r.headers == nil && r.cookies == nil {
+		return raw
+	}
+	var buf bytes.Buffer
+	// the status line
+	if idx := bytes.Index(raw, []byte("\r\n")); idx >= 0 {
+		buf.Write(raw[:idx+2])
+	}
+	ctx.Response.Header.VisitAll(func(key, value []byte) {
+		if _, ok := r.headers[strings.ToLower(string(key))]; ok {
+			value = []byte(redactedMask)
+		} else if r.cookies != nil && string(key) == "Set-Cookie" {
+			value = r.setCookie(value)
+		}
+		writeHeaderLine(&buf, key, value)
+	})
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+func writeHeaderLine(buf *bytes.Buffer, key, value []byte) {
+	buf.Write(key)
+	buf.WriteString(": ")
+	buf.Write(value)
+	buf.WriteString("\r\n")
+}
+
+// cookieHeader masks the values of a Cookie header like `a=1; b=2`
+func (r *redactor) cookieHeader(value []byte) []byte {
+	var buf bytes.Buffer
+	for i, pair := range bytes.Split(value, []byte("; ")) {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		buf.Write(r.maskPair(pair, r.cookies))
+	}
+	return buf.Bytes()
+}
+
+// setCookie masks the value of a Set-Cookie header like `a=1; Path=/`
+func (r *redactor) setCookie(value []byte) []byte {
+	idx := bytes.IndexByte(value, ';')
+	if idx < 0 {
+		return r.maskPair(value, r.cookies)
+	}
+	masked := r.maskPair(value[:idx], r.cookies)
+	return append(append([]byte(nil), masked...), value[idx:]...)
+}
+
+// queryString masks the values of the query params like `a=1&b=2`
+func (r *redactor) queryString(qs []byte) []byte {
+	if r.query == nil || len(qs) == 0 {
+		return qs
+	}
+	var buf bytes.Buffer
+	for i, pair := range bytes.Split(qs, []byte("&")) {
+		if i > 0 {
+			buf.WriteByte('&')
+		}
+		buf.Write(r.maskPair(pair, r.query))
+	}
+	return buf.Bytes()
+}
+
+// requestURI masks the query params of uri
+func (r *redactor) requestURI(uri []byte) []byte {
+	idx := bytes.IndexByte(uri, '?')
+	if r.query == nil || idx < 0 {
+		return uri
+	}
+	return append(append([]byte(nil), uri[:idx+1]...), r.queryString(uri[idx+1:])...)
+}
+
+// maskPair masks the value of `key=value` if key is in names
+func (r *redactor) maskPair(pair []byte, names map[string]struct{}) []byte {
+	idx := bytes.IndexByte(pair, '=')
+	if idx < 0 {
+		return pair
+	}
+	if _, ok := names[string(bytes.TrimSpace(pair[:idx]))]; !ok {
+		return pair
+	}
+	return append(append([]byte(nil), pair[:idx+1]...), redactedMask...)
+}
+
+// maskTag reports whether the header, cookie or query placeholder of templates should be masked
+func (r *redactor) maskTag(tag string) bool {
+	if len(tag) < 2 {
+		return false
+	}
+	var ok bool
+	switch tag[0] {
+	case '>', '<':
+		_, ok = r.headers[strings.ToLower(tag[1:])]
+	case '~':
+		_, ok = r.cookies[tag[1:]]
+	case '?':
+		_, ok = r.query[tag[1:]]
+	}
+	return ok
+}
+
+func (r *redactor) requestBody(ctx *fasthttp.RequestCtx) []byte {
+	// streamed bodies have been consumed by handlers
+	if ctx.Request.IsBodyStream() {
+		return []byte("-")
+	}
+	return r.body(ctx.Request.Header.ContentType(), ctx.Request.Body())
+}
+
+func (r *redactor) responseBody(ctx *fasthttp.RequestCtx) []byte {
+	// streamed bodies can't be read without consuming them
+	if ctx.Response.IsBodyStream() {
+		return []byte("-")
+	}
+	return r.body(ctx.Response.Header.ContentType(), ctx.Response.Body())
+}
+
+func (r *redactor) body(contentType, body []byte) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	if !r.allowBody(contentType) {
+		return []byte("-")
+	}
+	if r.maxBody > 0 && len(body) > r.maxBody {
+		return append(append([]byte(nil), body[:r.maxBody]...), truncatedMarker...)
+	}
+	return body
+}
+
+func (r *redactor) allowBody(contentType []byte) bool {
+	if len(r.bodyTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(string(contentType))
+	if err != nil {
+		return false
+	}
+	for _, t := range r.bodyTypes {
+		if t == mediaType {
+			return true
+		}
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package log
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestRedaction(t *testing.T) {
+	should := require.New(t)
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/login?user=foo&token=secret")
+	ctx.Request.Header.Set("Authorization", "Bearer xxx")
+	ctx.Request.Header.SetCookie("session", "abc")
+	ctx.Request.Header.SetCookie("theme", "dark")
+	ctx.Request.Header.SetContentType("application/json; charset=utf-8")
+	ctx.Request.SetBodyString(`{"password":"123456"}`)
+	ctx.Response.Header.Set("Set-Cookie", "session=def; Path=/")
+	ctx.Response.Header.SetContentType("image/png")
+	ctx.Response.SetBodyString("png")
+
+	cfg := LogConfig{
+		Format: []string{entryKeyRequestHeader, entryKeyQueryString, entryKeyRequestBody, entryKeyResponseHeader, entryKeyResponseBody},
+		Redact: Redaction{
+			Headers:   []string{"authorization"},
+			Cookies:   []string{"session"},
+			Query:     []string{"token"},
+			MaxBody:   8,
+			BodyTypes: []string{"application/json", "text/*"},
+		},
+	}
+	sink := &memSink{}
+	w, err := newEntityWriter(sink, cfg)
+	should.NoError(err)
+	w.Write(&ctx)
+	line := sink.String()
+	should.Contains(line, `POST /login?user=foo&token=*** HTTP/1.1\r\n`)
+	should.Contains(line, `Authorization: ***\r\n`)
+	should.Contains(line, `Cookie: session=***; theme=dark\r\n`)
+	should.Contains(line, `"query_string":"user=foo&token=***"`)
+	should.Contains(line, `"request_body":"{\"passwo...[truncated]"`)
+	should.Contains(line, `Set-Cookie: session=***; Path=/\r\n`)
+	should.Contains(line, `"response_body":"-"`)
+	should.False(strings.Contains(line, "secret") || strings.Contains(line, "xxx") || strings.Contains(line, "abc"))
+
+	cfg.FormatKind = FormatTemplate
+	cfg.Template = "{>Authorization} {~session} {~theme} {?token} {uri}"
+	sink = &memSink{}
+	w, err = newEntityWriter(sink, cfg)
+	should.NoError(err)
+	w.Write(&ctx)
+	should.Equal("*** *** dark *** /login?user=foo&token=***\n", sink.String())
+}
@@ -0,0 +1,207 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "20060102-150405.000"
+
+// RotateConfig decides when a log file is rotated, zero values disable the corresponding rule
+type RotateConfig struct {
+	// MaxSize rotates the file once it's larger than MaxSize bytes
+	MaxSize int
+	// Interval rotates the file at every multiple of Interval(UTC), such as 24h for midnight
+	Interval time.Duration
+	// Keep is the number of rotated files to retain, 0 keeps all
+	Keep     int
+	Compress bool
+}
+
+// logFile is a log file which rotates itself and can be reopened after rotated by others
+type logFile struct {
+	path string
+	cfg  RotateConfig
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	period time.Time
+	// cleaning serializes compressing and pruning of the backups
+	cleaning sync.Mutex
+}
+
+var (
+	openFilesMu sync.Mutex
+	openFiles   = make(map[*logFile]struct{})
+)
+
+func openLogFile(path string, cfg RotateConfig) (*logFile, error) {
+	l := &logFile{path: path, cfg: cfg}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	openFilesMu.Lock()
+	openFiles[l] = struct{}{}
+	openFilesMu.Unlock()
+	watchReopenSignal()
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	l.period = l.currentPeriod(time.Now())
+	return nil
+}
+
+func (l *logFile) currentPeriod(now time.Time) time.Time {
+	if l.cfg.Interval <= 0 {
+		return time.Time{}
+	}
+	return now.UTC().Truncate(l.cfg.Interval)
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.shouldRotate(len(p)) {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *logFile) shouldRotate(n int) bool {
+	if l.cfg.MaxSize > 0 && l.size > 0 && l.size+int64(n) > int64(l.cfg.MaxSize) {
+		return true
+	}
+	return l.cfg.Interval > 0 && !l.currentPeriod(time.Now()).Equal(l.period)
+}
+
+func (l *logFile) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Sync()
+}
+
+// Reopen reopens the file, which is used when the file is moved by external tools like logrotate
+func (l *logFile) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	old := l.f
+	// the old file is still used if it fails to open
+	if err := l.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+func (l *logFile) Close() error {
+	openFilesMu.Lock()
+	delete(openFiles, l)
+	openFilesMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// rotate renames the current file with a timestamp and opens a new one
+func (l *logFile) rotate() error {
+	old := l.f
+	backup := l.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(l.path, backup); err != nil && !os.IsNotExist(err) {
+		// keep writing to the original file and retry later,
+		// the logger can't be used since it may write to this file
+		fmt.Fprintf(os.Stderr, "[log] rotate %s error: %s\n", l.path, err)
+		l.size = 0
+		l.period = l.currentPeriod(time.Now())
+		return nil
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	old.Close()
+	if l.cfg.Compress || l.cfg.Keep > 0 {
+		go l.cleanBackups(backup)
+	}
+	return nil
+}
+
+func (l *logFile) cleanBackups(backup string) {
+	l.cleaning.Lock()
+	defer l.cleaning.Unlock()
+	if l.cfg.Compress {
+		if err := compressFile(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "[log] compress %s error: %s\n", backup, err)
+		}
+	}
+	if l.cfg.Keep <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(l.path + ".[0-9]*")
+	if err != nil {
+		return
+	}
+	// timestamps sort the backups from the oldest to the newest
+	sort.Strings(backups)
+	for i := 0; i < len(backups)-l.cfg.Keep; i++ {
+		os.Remove(backups[i])
+	}
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// ReopenAll reopens all the log files, it's called on SIGUSR1
+func ReopenAll() {
+	openFilesMu.Lock()
+	files := make([]*logFile, 0, len(openFiles))
+	for f := range openFiles {
+		files = append(files, f)
+	}
+	openFilesMu.Unlock()
+	for _, f := range files {
+		if err := f.Reopen(); err != nil {
+			fmt.Fprintf(os.Stderr, "[log] reopen %s error: %s\n", f.path, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogFile_RotateBySize(t *testing.T) {
+	should := require.New(t)
+	dir, err := ioutil.TempDir("", "durian-log")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	f, err := openLogFile(path, RotateConfig{MaxSize: 10, Keep: 2})
+	should.NoError(err)
+	defer f.Close()
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		_, err := f.Write([]byte(line))
+		should.NoError(err)
+		// backups are named by milliseconds
+		time.Sleep(2 * time.Millisecond)
+	}
+	content, err := ioutil.ReadFile(path)
+	should.NoError(err)
+	should.Equal("dddddddd\n", string(content))
+	// pruning runs in background
+	var backups []string
+	for i := 0; i < 100; i++ {
+		backups, _ = filepath.Glob(path + ".*")
+		if len(backups) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	should.Len(backups, 2)
+	content, err = ioutil.ReadFile(backups[1])
+	should.NoError(err)
+	should.Equal("cccccccc\n", string(content))
+}
+
+func TestLogFile_Compress(t *testing.T) {
+	should := require.New(t)
+	dir, err := ioutil.TempDir("", "durian-log")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "error.log")
+	f, err := openLogFile(path, RotateConfig{MaxSize: 4, Compress: true})
+	should.NoError(err)
+	defer f.Close()
+	f.Write([]byte("first\n"))
+	f.Write([]byte("second\n"))
+	var backups []string
+	for i := 0; i < 100; i++ {
+		backups, _ = filepath.Glob(path + ".*")
+		if len(backups) == 1 && strings.HasSuffix(backups[0], ".gz") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	should.Len(backups, 1)
+	should.True(strings.HasSuffix(backups[0], ".gz"))
+}
+
+func TestLogFile_Reopen(t *testing.T) {
+	should := require.New(t)
+	dir, err := ioutil.TempDir("", "durian-log")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	f, err := openLogFile(path, RotateConfig{})
+	should.NoError(err)
+	defer f.Close()
+	f.Write([]byte("before\n"))
+	// rotated by an external tool
+	should.NoError(os.Rename(path, path+".1"))
+	f.Write([]byte("moved\n"))
+	ReopenAll()
+	f.Write([]byte("after\n"))
+	content, err := ioutil.ReadFile(path)
+	should.NoError(err)
+	should.Equal("after\n", string(content))
+	content, err = ioutil.ReadFile(path + ".1")
+	should.NoError(err)
+	should.Equal("before\nmoved\n", string(content))
+}
@@ -0,0 +1,99 @@
+package log
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/valyala/fasthttp"
+)
+
+// Condition decides whether a request is logged, zero values match all the requests
+type Condition struct {
+	// Status is the inclusive ranges of status code
+	Status [][2]int
+	// MinLatency only logs the requests slower than it
+	MinLatency time.Duration
+	// SampleRate is the fraction of the matched requests to log, 0 means 1
+	SampleRate float64
+}
+
+func (cond *Condition) match(ctx *fasthttp.RequestCtx) bool {
+	if len(cond.Status) > 0 {
+		code := ctx.Response.StatusCode()
+		matched := false
+		for _, r := range cond.Status {
+			if code >= r[0] && code <= r[1] {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if cond.MinLatency > 0 && time.Since(ctx.Time()) < cond.MinLatency {
+		return false
+	}
+	if cond.SampleRate > 0 && cond.SampleRate < 1 && rand.Float64() >= cond.SampleRate {
+		return false
+	}
+	return true
+}
+
+// parseStatus parses status code like 404, 5xx or 500-503 into an inclusive range
+func parseStatus(s string) ([2]int, error) {
+	if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+		base := int(s[0]-'0') * 100
+		return [2]int{base, base + 99}, nil
+	}
+	parts := strings.SplitN(s, "-", 2)
+	from, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return [2]int{}, fmt.Errorf("invalid status %s", s)
+	}
+	to := from
+	if len(parts) == 2 {
+		if to, err = strconv.Atoi(parts[1]); err != nil || to < from {
+			return [2]int{}, fmt.Errorf("invalid status range %s", s)
+		}
+	}
+	return [2]int{from, to}, nil
+}
+
+// logRule writes the requests matching location and condition with writer, nothing is logged if writer is nil
+type logRule struct {
+	location  super.LocationMatcher
+	condition Condition
+	writer    EntityWriter
+}
+
+// logRules are all the log blocks of a server, the first rule whose location matches is used,
+// and the rule without location is the fallback
+type logRules struct {
+	scoped   []*logRule
+	fallback *logRule
+}
+
+func (rs *logRules) find(ctx *fasthttp.RequestCtx) *logRule {
+	for _, r := range rs.scoped {
+		if r.location.Match(ctx) {
+			return r
+		}
+	}
+	return rs.fallback
+}
+
+func (rs *logRules) middleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		// match the original request, since it may be rewritten by the handlers
+		r := rs.find(ctx)
+		next(ctx)
+		if r != nil && r.writer != nil && r.condition.match(ctx) {
+			r.writer.Write(ctx)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package log
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestParseStatus(t *testing.T) {
+	should := require.New(t)
+	testCases := []struct {
+		input  string
+		expect [2]int
+		err    bool
+	}{
+		{input: "404", expect: [2]int{404, 404}},
+		{input: "5xx", expect: [2]int{500, 599}},
+		{input: "500-503", expect: [2]int{500, 503}},
+		{input: "503-500", err: true},
+		{input: "abc", err: true},
+	}
+	for _, tc := range testCases {
+		r, err := parseStatus(tc.input)
+		if tc.err {
+			should.Error(err, tc.input)
+			continue
+		}
+		should.NoError(err, tc.input)
+		should.Equal(tc.expect, r, tc.input)
+	}
+}
+
+type countWriter struct {
+	n int
+}
+
+func (w *countWriter) Write(ctx *fasthttp.RequestCtx) {
+	w.n++
+}
+
+func TestLogRules(t *testing.T) {
+	should := require.New(t)
+	newMatcher := func(prefix string) super.LocationMatcher {
+		m, err := super.NewLocationMatcher([]string{prefix})
+		should.NoError(err)
+		return m
+	}
+	api, fallback := &countWriter{}, &countWriter{}
+	rules := &logRules{
+		scoped: []*logRule{
+			{location: newMatcher("/health")},
+			{location: newMatcher("/api"), writer: api, condition: Condition{Status: [][2]int{{500, 599}}}},
+		},
+		fallback: &logRule{writer: fallback, condition: Condition{MinLatency: time.Millisecond}},
+	}
+	var status int
+	var delay time.Duration
+	h := rules.middleware(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(status)
+		time.Sleep(delay)
+	})
+	// the start time of requests is set by fasthttp.Server
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go fasthttp.Serve(ln, h)
+	client := &fasthttp.Client{Dial: func(string) (net.Conn, error) {
+		return ln.Dial()
+	}}
+	serve := func(path string, code int, d time.Duration) {
+		status, delay = code, d
+		req, resp := &fasthttp.Request{}, &fasthttp.Response{}
+		req.SetRequestURI("http://a.com" + path)
+		should.NoError(client.Do(req, resp))
+		should.Equal(code, resp.StatusCode())
+	}
+	serve("/health", 500, 2*time.Millisecond)
+	serve("/api/foo", 200, 0)
+	serve("/api/foo", 502, 0)
+	serve("/index.html", 200, 0)
+	serve("/index.html", 200, 2*time.Millisecond)
+	should.Equal(1, api.n)
+	should.Equal(1, fallback.n)
+}
+
+func TestCondition_Sample(t *testing.T) {
+	should := require.New(t)
+	cond := Condition{SampleRate: 0.1}
+	var ctx fasthttp.RequestCtx
+	n := 0
+	for i := 0; i < 10000; i++ {
+		if cond.match(&ctx) {
+			n++
+		}
+	}
+	should.InDelta(1000, n, 300)
+}
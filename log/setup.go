@@ -1,11 +1,10 @@
 package log
 
 import (
-	"errors"
 	"fmt"
 	super "github.com/caibirdme/durian/server"
 	"github.com/mholt/caddy"
-	"github.com/valyala/fasthttp"
+	"go.uber.org/zap/zapcore"
 	"strconv"
 	"strings"
 	"time"
@@ -18,34 +17,120 @@ func init() {
 	})
 }
 
+type (
+	// rulesKey stores the rules of a server
+	rulesKey struct {
+		srv *super.ServerConfig
+	}
+	// outputsKey stores the access log outputs by path, so that a file is only opened once
+	outputsKey struct{}
+)
+
 func setupAccess(c *caddy.Controller) error {
 	cfg, err := parseConfig(c)
 	if err != nil {
 		return err
 	}
-	logWriter, sync, err := NewLogger(*cfg)
-	if nil != err {
-		return fmt.Errorf("[log] init log error: %s", err)
+	rules := getRules(c)
+	rule := &logRule{location: cfg.Location, condition: cfg.Condition}
+	if cfg.Location == nil {
+		if rules.fallback != nil {
+			return c.Err("[log] only one log block without location is allowed")
+		}
+		rules.fallback = rule
+	} else {
+		rules.scoped = append(rules.scoped, rule)
 	}
-	if sync != nil {
-		c.OnShutdown(sync)
+	if cfg.Off {
+		return nil
 	}
-	super.GetConfig(c).AddNamedMiddleware(super.LogMiddlewareName, func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
-		return func(ctx *fasthttp.RequestCtx) {
-			next(ctx)
-			logWriter.Write(ctx)
+	// the error logger is configured by the block without location
+	if cfg.Location == nil {
+		errLogger, closeErr, err := openErrLogger(*cfg)
+		if err != nil {
+			return fmt.Errorf("[log] init log error: %s", err)
 		}
-	})
-	return nil
+		globalLogger = errLogger
+		c.OnShutdown(closeErr)
+	}
+	out, err := getOutput(c, cfg)
+	if err != nil {
+		return fmt.Errorf("[log] init log error: %s", err)
+	}
+	rule.writer, err = newEntityWriter(out, *cfg)
+	return err
+}
+
+// getRules returns the rules of current server, the log middleware is registered on the first call
+func getRules(c *caddy.Controller) *logRules {
+	srv := super.GetConfig(c)
+	key := rulesKey{srv: srv}
+	if rules, ok := c.Get(key).(*logRules); ok {
+		return rules
+	}
+	rules := &logRules{}
+	c.Set(key, rules)
+	srv.AddNamedMiddleware(super.LogMiddlewareName, rules.middleware)
+	return rules
 }
 
+// getOutput opens the access log of cfg, the buffer and rotate options of the first block win if
+// several blocks write to the same file
+func getOutput(c *caddy.Controller, cfg *LogConfig) (zapcore.WriteSyncer, error) {
+	path, err := confirmPath(cfg.AccessPath, defaultAccessLogName)
+	if err != nil {
+		return nil, err
+	}
+	outputs, ok := c.Get(outputsKey{}).(map[string]zapcore.WriteSyncer)
+	if !ok {
+		outputs = make(map[string]zapcore.WriteSyncer)
+		c.Set(outputsKey{}, outputs)
+	}
+	if out, ok := outputs[path]; ok {
+		return out, nil
+	}
+	cfg.AccessPath = path
+	out, closer, err := openAccessOutput(*cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.OnShutdown(closer)
+	outputs[path] = out
+	return out, nil
+}
+
+//	log [path | ~ regexp] {
+//	    access_path /var/log/api.log
+//	    status 5xx
+//	    format json {request_body response_body}
+//	}
 func parseConfig(c *caddy.Controller) (*LogConfig, error) {
 	c.Next()
-	cfg := LogConfig{}
+	var location super.LocationMatcher
+	if args := c.RemainingArgs(); len(args) > 0 {
+		var err error
+		location, err = super.NewMatcher(c, args)
+		if err != nil {
+			return nil, c.Err(err.Error())
+		}
+	}
+	cfg := LogConfig{
+		Location: location,
+		Syslog:   SyslogConfig{Facility: syslogFacilities["local0"], Tag: defaultSyslogTag},
+		ErrLog: ErrLogConfig{
+			Level:            zapcore.InfoLevel,
+			Encoding:         EncodingJSON,
+			SampleInitial:    defaultSampleInitial,
+			SampleThereafter: defaultSampleThereafter,
+		},
+	}
 	block := false
 	for c.NextBlock() {
 		block = true
 		kind := c.Val()
+		if location != nil && strings.HasPrefix(strings.ToLower(kind), "err_") {
+			return nil, c.Errf("%s is only allowed in the log block without location", kind)
+		}
 		switch strings.ToLower(kind) {
 		case "access_path":
 			if !c.NextArg() {
@@ -58,16 +143,14 @@ func parseConfig(c *caddy.Controller) (*LogConfig, error) {
 			}
 			cfg.ErrPath = c.Val()
 		case "format":
-			format, err := parseFormat(c)
-			if err != nil {
+			if err := parseFormat(c, &cfg); err != nil {
 				return nil, err
 			}
-			cfg.Format = format
 		case "buffer":
 			if !c.NextArg() {
 				return nil, c.ArgErr()
 			}
-			size, err := parseSize(c.Val())
+			size, err := super.ParseSize(c.Val())
 			if nil != err {
 				return nil, c.Err(err.Error())
 			}
@@ -81,6 +164,153 @@ func parseConfig(c *caddy.Controller) (*LogConfig, error) {
 				return nil, c.Err(err.Error())
 			}
 			cfg.Flush = d
+		case "queue":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			n, err := strconv.Atoi(c.Val())
+			if nil != err || n <= 0 {
+				return nil, c.Errf("queue must be a positive int but %s", c.Val())
+			}
+			cfg.Queue = n
+		case "overflow":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			switch c.Val() {
+			case OverflowBlock, OverflowDrop:
+				cfg.Overflow = c.Val()
+			default:
+				return nil, c.Errf("overflow should be %s or %s but %s", OverflowBlock, OverflowDrop, c.Val())
+			}
+		case "rotate_size":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			size, err := super.ParseSize(c.Val())
+			if nil != err {
+				return nil, c.Err(err.Error())
+			}
+			cfg.Rotate.MaxSize = size
+		case "rotate_interval":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			d, err := time.ParseDuration(c.Val())
+			if nil != err || d <= 0 {
+				return nil, c.Errf("rotate_interval must be a positive duration but %s", c.Val())
+			}
+			cfg.Rotate.Interval = d
+		case "rotate_keep":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			n, err := strconv.Atoi(c.Val())
+			if nil != err || n < 0 {
+				return nil, c.Errf("rotate_keep must be a non-negative int but %s", c.Val())
+			}
+			cfg.Rotate.Keep = n
+		case "rotate_compress":
+			cfg.Rotate.Compress = true
+		case "syslog_facility":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			facility, ok := syslogFacilities[strings.ToLower(c.Val())]
+			if !ok {
+				return nil, c.Errf("unknown syslog_facility %s", c.Val())
+			}
+			cfg.Syslog.Facility = facility
+		case "syslog_tag":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			cfg.Syslog.Tag = c.Val()
+		case "redact_headers":
+			cfg.Redact.Headers = append(cfg.Redact.Headers, c.RemainingArgs()...)
+		case "redact_cookies":
+			cfg.Redact.Cookies = append(cfg.Redact.Cookies, c.RemainingArgs()...)
+		case "redact_query":
+			cfg.Redact.Query = append(cfg.Redact.Query, c.RemainingArgs()...)
+		case "max_body_size":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			size, err := super.ParseSize(c.Val())
+			if nil != err {
+				return nil, c.Err(err.Error())
+			}
+			cfg.Redact.MaxBody = size
+		case "body_types":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.ArgErr()
+			}
+			cfg.Redact.BodyTypes = append(cfg.Redact.BodyTypes, args...)
+		case "off":
+			cfg.Off = true
+		case "status":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.ArgErr()
+			}
+			for _, arg := range args {
+				r, err := parseStatus(arg)
+				if err != nil {
+					return nil, c.Err(err.Error())
+				}
+				cfg.Condition.Status = append(cfg.Condition.Status, r)
+			}
+		case "min_latency":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			d, err := time.ParseDuration(c.Val())
+			if nil != err {
+				return nil, c.Err(err.Error())
+			}
+			cfg.Condition.MinLatency = d
+		case "sample":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			rate, err := strconv.ParseFloat(c.Val(), 64)
+			if nil != err || rate <= 0 || rate > 1 {
+				return nil, c.Errf("sample must be in (0, 1] but %s", c.Val())
+			}
+			cfg.Condition.SampleRate = rate
+		case "err_level":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			if err := cfg.ErrLog.Level.UnmarshalText([]byte(c.Val())); err != nil {
+				return nil, c.Errf("unknown err_level %s", c.Val())
+			}
+		case "err_encoding":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			switch c.Val() {
+			case EncodingJSON, EncodingConsole:
+				cfg.ErrLog.Encoding = c.Val()
+			default:
+				return nil, c.Errf("err_encoding should be %s or %s but %s", EncodingJSON, EncodingConsole, c.Val())
+			}
+		case "err_sampling":
+			args := c.RemainingArgs()
+			if len(args) == 1 && args[0] == "off" {
+				cfg.ErrLog.SampleInitial = 0
+				break
+			}
+			if len(args) != 2 {
+				return nil, c.ArgErr()
+			}
+			initial, err1 := strconv.Atoi(args[0])
+			thereafter, err2 := strconv.Atoi(args[1])
+			if err1 != nil || err2 != nil || initial <= 0 || thereafter <= 0 {
+				return nil, c.Errf("err_sampling should be off or two positive ints but %v", args)
+			}
+			cfg.ErrLog.SampleInitial, cfg.ErrLog.SampleThereafter = initial, thereafter
 		}
 	}
 	if !block {
@@ -89,25 +319,6 @@ func parseConfig(c *caddy.Controller) (*LogConfig, error) {
 	return &cfg, nil
 }
 
-func parseSize(s string) (int, error) {
-	s = strings.Trim(s, " ")
-	size, err := strconv.Atoi(s[:len(s)-1])
-	if nil != err {
-		return 0, err
-	}
-	unit := s[len(s)-1]
-	switch unit {
-	case 'k', 'K':
-		return size * 1024, nil
-	case 'm', 'M':
-		return size * 1024 * 1024, nil
-	case 'g', 'G':
-		return size * 1024 * 1024 * 1024, nil
-	default:
-		return 0, errors.New("invalid unit")
-	}
-}
-
 var (
 	defaultFormat = []string{
 		entryKeyRemoteAddr,
@@ -123,12 +334,41 @@ var (
 	}
 )
 
-func parseFormat(c *caddy.Controller) ([]string, error) {
+//	format [json|logfmt] [{entries...}]
+//	format common|combined
+//	format template "{remote} - {method} {uri} {status} {latency_ms}"
+func parseFormat(c *caddy.Controller, cfg *LogConfig) error {
+	cfg.FormatKind = FormatJSON
 	if !c.NextArg() {
-		return defaultFormat, nil
+		cfg.Format = defaultFormat
+		return nil
 	}
 	if c.Val() != "{" {
-		return nil, c.ArgErr()
+		cfg.FormatKind = strings.ToLower(c.Val())
+		switch cfg.FormatKind {
+		case FormatCommon, FormatCombined:
+			if c.NextArg() {
+				return c.ArgErr()
+			}
+			return nil
+		case FormatTemplate:
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return c.ArgErr()
+			}
+			cfg.Template = args[0]
+			return nil
+		case FormatJSON, FormatLogfmt:
+			if !c.NextArg() {
+				cfg.Format = defaultFormat
+				return nil
+			}
+			if c.Val() != "{" {
+				return c.ArgErr()
+			}
+		default:
+			return c.Errf("unknown format %s", c.Val())
+		}
 	}
 	arr := make([]string, 0, 6)
 	hash := make(map[string]struct{})
@@ -144,15 +384,52 @@ func parseFormat(c *caddy.Controller) ([]string, error) {
 		}
 	}
 	if !foundRightParen {
-		return nil, c.Err("not found right paren")
+		return c.Err("not found right paren")
 	}
-	return arr, nil
+	cfg.Format = arr
+	return nil
 }
 
 type LogConfig struct {
+	// Location scopes the block, nil for the whole server
+	Location  super.LocationMatcher
+	Condition Condition
+	// Off disables the access log of Location
+	Off        bool
 	AccessPath string
 	ErrPath    string
+	// FormatKind is one of json(default), logfmt, common, combined and template,
+	// Format is the entries of json and logfmt, Template is used by template
+	FormatKind string
 	Format     []string
-	Buffer     int
-	Flush      time.Duration
+	Template   string
+	// the access log is written asynchronously if Buffer is set
+	Buffer int
+	Flush  time.Duration
+	// Queue is the max number of entries waiting to be written, Overflow decides what to do if it's full
+	Queue    int
+	Overflow string
+	// Rotate applies to both access and error log
+	Rotate RotateConfig
+	Syslog SyslogConfig
+	Redact Redaction
+	ErrLog ErrLogConfig
+}
+
+const (
+	EncodingJSON    = "json"
+	EncodingConsole = "console"
+
+	defaultSampleInitial    = 100
+	defaultSampleThereafter = 100
+)
+
+// ErrLogConfig configures the logger returned by GetLogger
+type ErrLogConfig struct {
+	Level    zapcore.Level
+	Encoding string
+	// the first SampleInitial entries with the same message in a second are logged,
+	// then one of every SampleThereafter ones. 0 SampleInitial disables sampling
+	SampleInitial    int
+	SampleThereafter int
 }
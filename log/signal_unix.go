@@ -0,0 +1,26 @@
+// +build !windows
+
+package log
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var watchOnce sync.Once
+
+// watchReopenSignal reopens all the log files on SIGUSR1, so that the files can be rotated by logrotate etc.
+// caddy also reloads the Caddyfile on SIGUSR1, the log files are reopened even if the reloading fails
+func watchReopenSignal() {
+	watchOnce.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGUSR1)
+		go func() {
+			for range ch {
+				ReopenAll()
+			}
+		}()
+	})
+}
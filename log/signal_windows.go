@@ -0,0 +1,4 @@
+package log
+
+// watchReopenSignal does nothing since there is no SIGUSR1 on windows
+func watchReopenSignal() {}
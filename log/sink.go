@@ -0,0 +1,278 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	sinkStdout = "stdout"
+	sinkStderr = "stderr"
+
+	dialTimeout  = 3 * time.Second
+	writeTimeout = 5 * time.Second
+	minBackoff   = 100 * time.Millisecond
+	maxBackoff   = 30 * time.Second
+
+	defaultSyslogTag = "durian"
+	// syslog severities of access and error log
+	severityInfo = 6
+	severityErr  = 3
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogConfig is used by syslog sinks
+type SyslogConfig struct {
+	Facility int
+	Tag      string
+}
+
+// sink is where the log entries go, entries written to it always end with '\n'
+type sink interface {
+	zapcore.WriteSyncer
+	Close() error
+}
+
+// isFile reports whether path is a file rather than stdout, stderr or a network sink
+func isFile(path string) bool {
+	return path != sinkStdout && path != sinkStderr && !isRemote(path)
+}
+
+// isRemote reports whether path is a network sink
+func isRemote(path string) bool {
+	return strings.Contains(path, "://")
+}
+
+// openSink opens path, which is one of:
+//	a file path
+//	stdout, stderr
+//	tcp://host:port, udp://host:port, unix:///path/to/socket: newline delimited entries
+//	syslog://host:port(udp), syslog+tcp://host:port, syslog+unix:///dev/log: RFC5424 syslog
+func openSink(path string, cfg LogConfig, severity int) (sink, error) {
+	switch path {
+	case sinkStdout:
+		return stdSink{os.Stdout}, nil
+	case sinkStderr:
+		return stdSink{os.Stderr}, nil
+	}
+	if isFile(path) {
+		return openLogFile(path, cfg.Rotate)
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if strings.HasSuffix(u.Scheme, "unix") {
+		addr = u.Path
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("no address in %s", path)
+	}
+	switch u.Scheme {
+	case "tcp", "udp", "unix":
+		return newNetSink(u.Scheme, addr, nil), nil
+	case "syslog", "syslog+udp":
+		return newNetSink("udp", addr, newSyslogFramer(cfg.Syslog, severity, false)), nil
+	case "syslog+tcp":
+		return newNetSink("tcp", addr, newSyslogFramer(cfg.Syslog, severity, true)), nil
+	case "syslog+unix":
+		return newNetSink("unixgram", addr, newSyslogFramer(cfg.Syslog, severity, false)), nil
+	default:
+		return nil, fmt.Errorf("unknown log sink %s", path)
+	}
+}
+
+// stdSink never closes stdout and stderr
+type stdSink struct {
+	f *os.File
+}
+
+func (s stdSink) Write(p []byte) (int, error) { return s.f.Write(p) }
+
+// Sync ignores the error, since stdout can't be synced if it's a terminal or pipe
+func (s stdSink) Sync() error {
+	s.f.Sync()
+	return nil
+}
+
+func (s stdSink) Close() error { return nil }
+
+// framer appends a line without '\n' as a message to dst
+type framer func(dst, line []byte) []byte
+
+func newlineFramer(dst, line []byte) []byte {
+	dst = append(dst, line...)
+	return append(dst, '\n')
+}
+
+var hostname = func() string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		return "-"
+	}
+	return h
+}()
+
+// newSyslogFramer frames lines as RFC5424 messages, octetCounting is used by stream transports(RFC6587)
+func newSyslogFramer(cfg SyslogConfig, severity int, octetCounting bool) framer {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+	prefix := "<" + strconv.Itoa(cfg.Facility*8+severity) + ">1 "
+	suffix := " " + hostname + " " + tag + " " + strconv.Itoa(os.Getpid()) + " - - "
+	return func(dst, line []byte) []byte {
+		ts := time.Now().Format("2006-01-02T15:04:05.000000Z07:00")
+		if octetCounting {
+			n := len(prefix) + len(ts) + len(suffix) + len(line)
+			dst = strconv.AppendInt(dst, int64(n), 10)
+			dst = append(dst, ' ')
+		}
+		dst = append(dst, prefix...)
+		dst = append(dst, ts...)
+		dst = append(dst, suffix...)
+		return append(dst, line...)
+	}
+}
+
+// netSink writes entries to a remote address, it reconnects in background with exponential backoff,
+// and entries written while it's disconnected are dropped rather than blocking the requests
+type netSink struct {
+	network string
+	addr    string
+	frame   framer
+	// datagram sinks send each entry in a packet
+	datagram bool
+
+	mu      sync.Mutex
+	conn    net.Conn
+	buf     []byte
+	dialing bool
+	closed  bool
+	backoff time.Duration
+}
+
+func newNetSink(network, addr string, frame framer) *netSink {
+	if frame == nil {
+		frame = newlineFramer
+	}
+	s := &netSink{
+		network:  network,
+		addr:     addr,
+		frame:    frame,
+		datagram: network == "udp" || network == "unixgram",
+		backoff:  minBackoff,
+	}
+	// the remote may be unavailable at startup, which isn't fatal
+	if conn, err := net.DialTimeout(network, addr, dialTimeout); err == nil {
+		s.conn = conn
+	} else {
+		fmt.Fprintf(os.Stderr, "[log] connect %s://%s error: %s\n", network, addr, err)
+		s.mu.Lock()
+		s.reconnect()
+		s.mu.Unlock()
+	}
+	return s
+}
+
+func (s *netSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := bytes.Split(bytes.TrimSuffix(p, []byte{'\n'}), []byte{'\n'})
+	if s.conn == nil {
+		s.reconnect()
+		atomic.AddUint64(&droppedEntries, uint64(len(lines)))
+		return len(p), nil
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	var err error
+	if s.datagram {
+		for i, line := range lines {
+			s.buf = s.frame(s.buf[:0], line)
+			if _, err = s.conn.Write(s.buf); err != nil {
+				atomic.AddUint64(&droppedEntries, uint64(len(lines)-i))
+				break
+			}
+		}
+	} else {
+		s.buf = s.buf[:0]
+		for _, line := range lines {
+			s.buf = s.frame(s.buf, line)
+		}
+		if _, err = s.conn.Write(s.buf); err != nil {
+			atomic.AddUint64(&droppedEntries, uint64(len(lines)))
+		}
+	}
+	if err != nil {
+		// the logger can't be used since it may write to this sink
+		fmt.Fprintf(os.Stderr, "[log] write %s://%s error: %s\n", s.network, s.addr, err)
+		s.conn.Close()
+		s.conn = nil
+		s.reconnect()
+	}
+	return len(p), nil
+}
+
+// reconnect dials in background, caller must hold s.mu
+func (s *netSink) reconnect() {
+	if s.dialing || s.closed {
+		return
+	}
+	s.dialing = true
+	go func() {
+		for {
+			conn, err := net.DialTimeout(s.network, s.addr, dialTimeout)
+			s.mu.Lock()
+			if s.closed {
+				s.dialing = false
+				s.mu.Unlock()
+				if conn != nil {
+					conn.Close()
+				}
+				return
+			}
+			if err == nil {
+				s.conn, s.dialing, s.backoff = conn, false, minBackoff
+				s.mu.Unlock()
+				return
+			}
+			backoff := s.backoff
+			if s.backoff *= 2; s.backoff > maxBackoff {
+				s.backoff = maxBackoff
+			}
+			s.mu.Unlock()
+			time.Sleep(backoff)
+		}
+	}()
+}
+
+func (s *netSink) Sync() error { return nil }
+
+func (s *netSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,121 @@
+package log
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetSink_TCP(t *testing.T) {
+	should := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	addr := ln.Addr().String()
+	// the sink is opened before the remote is available
+	ln.Close()
+	s, err := openSink("tcp://"+addr, LogConfig{}, severityInfo)
+	should.NoError(err)
+	defer s.Close()
+	_, err = s.Write([]byte("dropped\n"))
+	should.NoError(err)
+
+	ln, err = net.Listen("tcp", addr)
+	should.NoError(err)
+	defer ln.Close()
+	received := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			received <- line
+		}
+	}()
+	// wait for reconnecting
+	for i := 0; i < 100; i++ {
+		ns := s.(*netSink)
+		ns.mu.Lock()
+		connected := ns.conn != nil
+		ns.mu.Unlock()
+		if connected {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err = s.Write([]byte("first\nsecond\n"))
+	should.NoError(err)
+	should.Equal("first\n", <-received)
+	should.Equal("second\n", <-received)
+}
+
+func TestNetSink_Syslog(t *testing.T) {
+	should := require.New(t)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	should.NoError(err)
+	defer pc.Close()
+	cfg := LogConfig{Syslog: SyslogConfig{Facility: syslogFacilities["local0"], Tag: "test"}}
+	s, err := openSink("syslog://"+pc.LocalAddr().String(), cfg, severityInfo)
+	should.NoError(err)
+	defer s.Close()
+	_, err = s.Write([]byte(`{"a":1}` + "\n" + `{"b":2}` + "\n"))
+	should.NoError(err)
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	for _, expect := range []string{`{"a":1}`, `{"b":2}`} {
+		n, _, err := pc.ReadFrom(buf)
+		should.NoError(err)
+		msg := string(buf[:n])
+		should.True(strings.HasPrefix(msg, "<134>1 "), msg)
+		should.Contains(msg, " test ")
+		should.True(strings.HasSuffix(msg, " - - "+expect), msg)
+	}
+}
+
+func TestSyslogFramer_OctetCounting(t *testing.T) {
+	should := require.New(t)
+	frame := newSyslogFramer(SyslogConfig{Facility: 1, Tag: "durian"}, severityErr, true)
+	msg := string(frame(nil, []byte("hello")))
+	idx := strings.IndexByte(msg, ' ')
+	should.Equal(strconv.Itoa(len(msg)-idx-1), msg[:idx])
+	should.True(strings.HasPrefix(msg[idx+1:], "<11>1 "))
+}
+
+func TestOpenAccessOutput_StalledRemote(t *testing.T) {
+	should := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	defer ln.Close()
+	// the collector accepts but never reads
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	// no buffer is set, remote sinks are still written in background
+	out, closer, err := openAccessOutput(LogConfig{AccessPath: "tcp://" + ln.Addr().String()})
+	should.NoError(err)
+	should.IsType(&asyncWriter{}, out)
+
+	entry := []byte(strings.Repeat("x", 1023) + "\n")
+	start := time.Now()
+	for i := 0; i < 20000; i++ {
+		_, err := out.Write(entry)
+		should.NoError(err)
+	}
+	should.True(time.Since(start) < time.Second, "writing is blocked by the remote")
+	(<-accepted).Close()
+	should.NoError(closer())
+}
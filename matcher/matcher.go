@@ -0,0 +1,170 @@
+package matcher
+
+import (
+	"bytes"
+	"net"
+	"strings"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/valyala/fasthttp"
+)
+
+// allOf matches if all the matchers match, it's the body of a matcher block
+type allOf []super.LocationMatcher
+
+func (ms allOf) Match(ctx *fasthttp.RequestCtx) bool {
+	for _, m := range ms {
+		if !m.Match(ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+type anyOf []super.LocationMatcher
+
+func (ms anyOf) Match(ctx *fasthttp.RequestCtx) bool {
+	for _, m := range ms {
+		if m.Match(ctx) {
+			return true
+		}
+	}
+	return false
+}
+
+type not struct {
+	m super.LocationMatcher
+}
+
+func (n not) Match(ctx *fasthttp.RequestCtx) bool {
+	return !n.m.Match(ctx)
+}
+
+// hostMatcher matches the Host header without port, `*.example.com` matches all the subdomains
+type hostMatcher struct {
+	exact    map[string]struct{}
+	suffixes []string
+}
+
+func newHostMatcher(hosts []string) *hostMatcher {
+	h := &hostMatcher{exact: make(map[string]struct{})}
+	for _, host := range hosts {
+		host = strings.ToLower(host)
+		if strings.HasPrefix(host, "*.") {
+			h.suffixes = append(h.suffixes, host[1:])
+		} else {
+			h.exact[host] = struct{}{}
+		}
+	}
+	return h
+}
+
+func (h *hostMatcher) Match(ctx *fasthttp.RequestCtx) bool {
+	host := strings.ToLower(string(ctx.Host()))
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	if _, ok := h.exact[host]; ok {
+		return true
+	}
+	for _, suffix := range h.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+type methodMatcher map[string]struct{}
+
+func newMethodMatcher(methods []string) methodMatcher {
+	m := make(methodMatcher, len(methods))
+	for _, method := range methods {
+		m[strings.ToUpper(method)] = struct{}{}
+	}
+	return m
+}
+
+func (m methodMatcher) Match(ctx *fasthttp.RequestCtx) bool {
+	_, ok := m[string(ctx.Method())]
+	return ok
+}
+
+// valueMatcher matches if the value exists and equals one of values, any value is accepted if values is empty
+type valueMatcher struct {
+	name   string
+	values [][]byte
+	peek   func(ctx *fasthttp.RequestCtx, name string) ([]byte, bool)
+}
+
+func (v *valueMatcher) Match(ctx *fasthttp.RequestCtx) bool {
+	value, ok := v.peek(ctx, v.name)
+	if !ok {
+		return false
+	}
+	if len(v.values) == 0 {
+		return true
+	}
+	for _, expect := range v.values {
+		if bytes.Equal(value, expect) {
+			return true
+		}
+	}
+	return false
+}
+
+func newValueMatcher(name string, values []string, peek func(ctx *fasthttp.RequestCtx, name string) ([]byte, bool)) *valueMatcher {
+	v := &valueMatcher{name: name, peek: peek}
+	for _, value := range values {
+		v.values = append(v.values, []byte(value))
+	}
+	return v
+}
+
+func peekHeader(ctx *fasthttp.RequestCtx, name string) ([]byte, bool) {
+	value := ctx.Request.Header.Peek(name)
+	// Peek can't tell an empty header from a missing one
+	return value, value != nil
+}
+
+func peekQuery(ctx *fasthttp.RequestCtx, name string) ([]byte, bool) {
+	args := ctx.QueryArgs()
+	return args.Peek(name), args.Has(name)
+}
+
+func peekCookie(ctx *fasthttp.RequestCtx, name string) ([]byte, bool) {
+	value := ctx.Request.Header.Cookie(name)
+	return value, value != nil
+}
+
+// remoteMatcher matches the client ip against networks
+type remoteMatcher []*net.IPNet
+
+func newRemoteMatcher(addrs []string) (remoteMatcher, error) {
+	m := make(remoteMatcher, 0, len(addrs))
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			if strings.Contains(addr, ":") {
+				addr += "/128"
+			} else {
+				addr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, network)
+	}
+	return m, nil
+}
+
+func (m remoteMatcher) Match(ctx *fasthttp.RequestCtx) bool {
+	ip := ctx.RemoteIP()
+	for _, network := range m {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
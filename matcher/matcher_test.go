@@ -0,0 +1,94 @@
+package matcher
+
+import (
+	"net"
+	"testing"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/mholt/caddy"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func newCtx(method, uri, remote string, headers map[string]string) *fasthttp.RequestCtx {
+	var req fasthttp.Request
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP(remote)}, nil)
+	return ctx
+}
+
+func TestParseBlock(t *testing.T) {
+	should := require.New(t)
+	c := caddy.NewTestController(super.FastHTTPServerType, `matcher api {
+		host api.example.com *.example.org
+		method get POST
+		path /api /v2
+		or {
+			header X-Env prod staging
+			query debug
+			cookie role admin
+		}
+		not {
+			remote 10.0.0.0/8 ::1
+		}
+	}`)
+	c.Next()
+	c.NextArg()
+	c.NextArg()
+	m, err := parseBlock(c)
+	should.NoError(err)
+
+	testCases := []struct {
+		method, uri, remote string
+		headers             map[string]string
+		expect              bool
+	}{
+		{method: "GET", uri: "http://api.example.com/api/users?debug", remote: "1.1.1.1", expect: true},
+		{method: "POST", uri: "http://a.b.example.org:8080/v2", remote: "1.1.1.1", headers: map[string]string{"X-Env": "prod"}, expect: true},
+		{method: "GET", uri: "http://api.example.com/api", remote: "1.1.1.1", headers: map[string]string{"Cookie": "a=1; role=admin"}, expect: true},
+		// none of the or block matches
+		{method: "GET", uri: "http://api.example.com/api", remote: "1.1.1.1", headers: map[string]string{"X-Env": "dev", "Cookie": "role=user"}},
+		{method: "PUT", uri: "http://api.example.com/api?debug", remote: "1.1.1.1"},
+		{method: "GET", uri: "http://example.com/api?debug", remote: "1.1.1.1"},
+		{method: "GET", uri: "http://api.example.com/web?debug", remote: "1.1.1.1"},
+		{method: "GET", uri: "http://api.example.com/api?debug", remote: "10.1.2.3"},
+		{method: "GET", uri: "http://api.example.com/api?debug", remote: "::1"},
+	}
+	for i, tc := range testCases {
+		should.Equal(tc.expect, m.Match(newCtx(tc.method, tc.uri, tc.remote, tc.headers)), "case %d", i)
+	}
+}
+
+func TestParseBlock_PathRegexp(t *testing.T) {
+	should := require.New(t)
+	c := caddy.NewTestController(super.FastHTTPServerType, "{\n path ~ \\.php$ \n}")
+	c.Next()
+	m, err := parseBlock(c)
+	should.NoError(err)
+	should.True(m.Match(newCtx("GET", "/index.php", "1.1.1.1", nil)))
+	should.False(m.Match(newCtx("GET", "/index.html", "1.1.1.1", nil)))
+}
+
+func TestParseBlock_Error(t *testing.T) {
+	should := require.New(t)
+	for _, input := range []string{
+		"{\n host \n}",
+		"{\n unknown a \n}",
+		"{\n remote 10.0.0.0/33 \n}",
+		"{\n not \n}",
+		"{\n not a \n}",
+		"{\n path ~ ( \n}",
+		"{\n @undefined \n}",
+		"{\n method GET \n",
+	} {
+		c := caddy.NewTestController(super.FastHTTPServerType, input)
+		c.Next()
+		_, err := parseBlock(c)
+		should.Error(err, input)
+	}
+}
@@ -0,0 +1,125 @@
+package matcher
+
+import (
+	"strings"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/mholt/caddy"
+)
+
+func init() {
+	caddy.RegisterPlugin(super.DirectiveMatcher, caddy.Plugin{
+		ServerType: super.FastHTTPServerType,
+		Action:     setup,
+	})
+}
+
+func setup(c *caddy.Controller) error {
+	// all the matchers of a server block are dispensed together, a matcher can refer the ones before it
+	for c.Next() {
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		name := c.Val()
+		if !c.NextArg() || c.Val() != "{" {
+			return c.SyntaxErr("{")
+		}
+		m, err := parseBlock(c)
+		if err != nil {
+			return err
+		}
+		if err := super.GetConfig(c).AddNamedMatcher(name, m); err != nil {
+			return c.Err(err.Error())
+		}
+	}
+	return nil
+}
+
+//	matcher api {
+//	    host api.example.com *.example.org
+//	    method GET POST
+//	    path /api
+//	    not {
+//	        remote 10.0.0.0/8
+//	    }
+//	}
+//
+// parseBlock parses the conditions until the closing brace, all of which must match
+func parseBlock(c *caddy.Controller) (allOf, error) {
+	var ms allOf
+	for {
+		if !c.Next() {
+			return nil, c.EOFErr()
+		}
+		if c.Val() == "}" {
+			return ms, nil
+		}
+		m, err := parseCondition(c)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+}
+
+func parseCondition(c *caddy.Controller) (super.LocationMatcher, error) {
+	kind := c.Val()
+	if strings.HasPrefix(kind, "@") {
+		return super.NewMatcher(c, []string{kind})
+	}
+	args := c.RemainingArgs()
+	switch strings.ToLower(kind) {
+	case "and", "or", "not":
+		if len(args) > 0 || !c.NextArg() || c.Val() != "{" {
+			return nil, c.SyntaxErr("{")
+		}
+		ms, err := parseBlock(c)
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(kind) {
+		case "and":
+			return ms, nil
+		case "or":
+			return anyOf(ms), nil
+		default:
+			return not{m: ms}, nil
+		}
+	}
+	if len(args) == 0 {
+		return nil, c.ArgErr()
+	}
+	switch strings.ToLower(kind) {
+	case "path":
+		if args[0] == "~" {
+			m, err := super.NewLocationMatcher(args)
+			if err != nil || len(args) != 2 {
+				return nil, c.Errf("invalid path %v", args)
+			}
+			return m, nil
+		}
+		var ms anyOf
+		for _, prefix := range args {
+			m, _ := super.NewLocationMatcher([]string{prefix})
+			ms = append(ms, m)
+		}
+		return ms, nil
+	case "host":
+		return newHostMatcher(args), nil
+	case "method":
+		return newMethodMatcher(args), nil
+	case "header":
+		return newValueMatcher(args[0], args[1:], peekHeader), nil
+	case "query":
+		return newValueMatcher(args[0], args[1:], peekQuery), nil
+	case "cookie":
+		return newValueMatcher(args[0], args[1:], peekCookie), nil
+	case "remote":
+		m, err := newRemoteMatcher(args)
+		if err != nil {
+			return nil, c.Err(err.Error())
+		}
+		return m, nil
+	}
+	return nil, c.Errf("unknown condition %s", kind)
+}
@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caibirdme/durian/upstream"
+)
+
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// knownMethods keeps the cardinality of method label bounded, others are reported as OTHER
+var knownMethods = map[string]struct{}{
+	"GET": {}, "HEAD": {}, "POST": {}, "PUT": {}, "DELETE": {},
+	"CONNECT": {}, "OPTIONS": {}, "TRACE": {}, "PATCH": {},
+}
+
+type requestKey struct {
+	server   string
+	location string
+	method   string
+	status   int
+}
+
+// histogram is a prometheus histogram updated lock free
+type histogram struct {
+	// counts[i] is the number of observations in (buckets[i-1], buckets[i]], the last one is +Inf
+	counts []uint64
+	count  uint64
+	// sum is in nanoseconds
+	sum uint64
+}
+
+// Registry collects the metrics of all the servers
+type Registry struct {
+	buckets []float64
+
+	mu       sync.RWMutex
+	requests map[requestKey]*histogram
+}
+
+func NewRegistry(buckets []float64) *Registry {
+	if len(buckets) == 0 {
+		buckets = defaultBuckets
+	}
+	return &Registry{buckets: buckets, requests: make(map[requestKey]*histogram)}
+}
+
+// Observe records a finished request
+func (r *Registry) Observe(server, location string, method []byte, status int, d time.Duration) {
+	key := requestKey{server: server, location: location, method: "OTHER", status: status}
+	if _, ok := knownMethods[string(method)]; ok {
+		key.method = string(method)
+	}
+	r.mu.RLock()
+	h, ok := r.requests[key]
+	r.mu.RUnlock()
+	if !ok {
+		r.mu.Lock()
+		if h, ok = r.requests[key]; !ok {
+			h = &histogram{counts: make([]uint64, len(r.buckets)+1)}
+			r.requests[key] = h
+		}
+		r.mu.Unlock()
+	}
+	seconds := d.Seconds()
+	idx := sort.SearchFloat64s(r.buckets, seconds)
+	atomic.AddUint64(&h.counts[idx], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+}
+
+// WriteTo writes all the metrics in prometheus text format
+func (r *Registry) WriteTo(w io.Writer, groups []*upstream.Group) (int64, error) {
+	var buf bytes.Buffer
+	r.writeRequests(&buf)
+	writeUpstreams(&buf, groups)
+	return buf.WriteTo(w)
+}
+
+func (r *Registry) writeRequests(buf *bytes.Buffer) {
+	r.mu.RLock()
+	keys := make([]requestKey, 0, len(r.requests))
+	for k := range r.requests {
+		keys = append(keys, k)
+	}
+	r.mu.RUnlock()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.server != b.server {
+			return a.server < b.server
+		}
+		if a.location != b.location {
+			return a.location < b.location
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	buf.WriteString("# HELP durian_requests_total Number of requests.\n")
+	buf.WriteString("# TYPE durian_requests_total counter\n")
+	for _, k := range keys {
+		h := r.get(k)
+		writeSample(buf, "durian_requests_total", k.labels(), strconv.FormatUint(atomic.LoadUint64(&h.count), 10))
+	}
+
+	buf.WriteString("# HELP durian_request_duration_seconds Latency of requests.\n")
+	buf.WriteString("# TYPE durian_request_duration_seconds histogram\n")
+	for _, k := range keys {
+		h := r.get(k)
+		labels := k.labels()
+		var cumulative uint64
+		for i, le := range r.buckets {
+			cumulative += atomic.LoadUint64(&h.counts[i])
+			writeSample(buf, "durian_request_duration_seconds_bucket",
+				append(labels, "le", strconv.FormatFloat(le, 'g', -1, 64)), strconv.FormatUint(cumulative, 10))
+		}
+		count := atomic.LoadUint64(&h.count)
+		writeSample(buf, "durian_request_duration_seconds_bucket", append(labels, "le", "+Inf"), strconv.FormatUint(count, 10))
+		sum := time.Duration(atomic.LoadUint64(&h.sum)).Seconds()
+		writeSample(buf, "durian_request_duration_seconds_sum", labels, strconv.FormatFloat(sum, 'g', -1, 64))
+		writeSample(buf, "durian_request_duration_seconds_count", labels, strconv.FormatUint(count, 10))
+	}
+}
+
+func (r *Registry) get(k requestKey) *histogram {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.requests[k]
+}
+
+func (k requestKey) labels() []string {
+	return []string{"server", k.server, "location", k.location, "method", k.method, "status", strconv.Itoa(k.status)}
+}
+
+func writeUpstreams(buf *bytes.Buffer, groups []*upstream.Group) {
+	type metric struct {
+		name, help, typ string
+		value           func(p *upstream.Peer) string
+	}
+	boolValue := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+	metrics := []metric{
+		{"durian_upstream_active_requests", "Number of requests in flight to the backend, each of which holds a connection.", "gauge",
+			func(p *upstream.Peer) string { return strconv.FormatInt(p.Active(), 10) }},
+		{"durian_upstream_failures_total", "Number of failed requests to the backend.", "counter",
+			func(p *upstream.Peer) string { return strconv.FormatUint(p.Failures(), 10) }},
+		{"durian_upstream_healthy", "Whether the backend passes the active health check.", "gauge",
+			func(p *upstream.Peer) string { return boolValue(p.Healthy()) }},
+		{"durian_upstream_available", "Whether the backend can be selected.", "gauge",
+			func(p *upstream.Peer) string { return boolValue(p.Available()) }},
+	}
+	for _, m := range metrics {
+		buf.WriteString("# HELP " + m.name + " " + m.help + "\n")
+		buf.WriteString("# TYPE " + m.name + " " + m.typ + "\n")
+		for _, g := range groups {
+			for _, p := range g.Peers() {
+				writeSample(buf, m.name, []string{"upstream", g.Name, "addr", p.String()}, m.value(p))
+			}
+		}
+	}
+}
+
+// writeSample writes a line like `name{k1="v1",k2="v2"} value`, labels are key value pairs
+func writeSample(buf *bytes.Buffer, name string, labels []string, value string) {
+	buf.WriteString(name)
+	if len(labels) > 0 {
+		buf.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(labels[i])
+			buf.WriteString(`="`)
+			buf.WriteString(labelEscaper.Replace(labels[i+1]))
+			buf.WriteByte('"')
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
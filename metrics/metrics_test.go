@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/caibirdme/durian/upstream"
+	"github.com/mholt/caddy"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	should := require.New(t)
+	r := NewRegistry([]float64{0.1, 1})
+	r.Observe(":8080", "/api", []byte("GET"), 200, 50*time.Millisecond)
+	r.Observe(":8080", "/api", []byte("GET"), 200, 500*time.Millisecond)
+	r.Observe(":8080", "/api", []byte("GET"), 200, 2*time.Second)
+	r.Observe(":8080", "-", []byte("PROPFIND"), 404, time.Millisecond)
+
+	g := upstream.NewGroup(super.Upstream{Name: "php", Backends: []super.Backend{{Network: "unix", Addr: "/run/fpm.sock", Weight: 1}}})
+	g.Peers()[0].MarkFailure()
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf, []*upstream.Group{g})
+	should.NoError(err)
+	out := buf.String()
+	for _, line := range []string{
+		`durian_requests_total{server=":8080",location="-",method="OTHER",status="404"} 1`,
+		`durian_requests_total{server=":8080",location="/api",method="GET",status="200"} 3`,
+		`durian_request_duration_seconds_bucket{server=":8080",location="/api",method="GET",status="200",le="0.1"} 1`,
+		`durian_request_duration_seconds_bucket{server=":8080",location="/api",method="GET",status="200",le="1"} 2`,
+		`durian_request_duration_seconds_bucket{server=":8080",location="/api",method="GET",status="200",le="+Inf"} 3`,
+		`durian_request_duration_seconds_sum{server=":8080",location="/api",method="GET",status="200"} 2.55`,
+		`durian_request_duration_seconds_count{server=":8080",location="/api",method="GET",status="200"} 3`,
+		`durian_upstream_active_requests{upstream="php",addr="unix:/run/fpm.sock"} 0`,
+		`durian_upstream_failures_total{upstream="php",addr="unix:/run/fpm.sock"} 1`,
+		`durian_upstream_healthy{upstream="php",addr="unix:/run/fpm.sock"} 1`,
+		`# TYPE durian_request_duration_seconds histogram`,
+	} {
+		should.Contains(out, line+"\n")
+	}
+	// samples are sorted by labels
+	should.True(strings.Index(out, `location="-"`) < strings.Index(out, `location="/api"`))
+}
+
+func TestWriteSample_Escape(t *testing.T) {
+	should := require.New(t)
+	var buf bytes.Buffer
+	writeSample(&buf, "m", []string{"k", "a\"b\\c\nd"}, "1")
+	should.Equal(`m{k="a\"b\\c\nd"} 1`+"\n", buf.String())
+}
+
+func TestParseConfig(t *testing.T) {
+	should := require.New(t)
+	c := caddy.NewTestController(super.FastHTTPServerType, `metrics /_metrics {
+		location /api
+		location ~ ^/user/\d+$
+		buckets 0.1 1 10
+	}`)
+	cfg, err := parseConfig(c)
+	should.NoError(err)
+	should.Equal("/_metrics", cfg.Path)
+	should.Equal([]float64{0.1, 1, 10}, cfg.Buckets)
+	should.Len(cfg.Locations, 2)
+	location := func(path string) string {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(path)
+		return cfg.location(ctx)
+	}
+	should.Equal("/api", location("/api/v1"))
+	should.Equal(`^/user/\d+$`, location("/user/12"))
+	should.Equal(noLocation, location("/"))
+
+	for _, input := range []string{
+		"metrics /a /b",
+		"metrics {\n buckets 1 0.1 \n}",
+		"metrics {\n buckets -1 \n}",
+		"metrics {\n unknown \n}",
+	} {
+		_, err := parseConfig(caddy.NewTestController(super.FastHTTPServerType, input))
+		should.Error(err, input)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	should := require.New(t)
+	c := caddy.NewTestController(super.FastHTTPServerType, "metrics")
+	cfg, err := parseConfig(c)
+	should.NoError(err)
+	registry := NewRegistry(nil)
+	handler := newMiddleware(c, ":80", cfg, registry)(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/foo")
+	ctx.Request.Header.SetMethod("POST")
+	handler(ctx)
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(defaultPath)
+	handler(ctx)
+	should.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+	should.Contains(string(ctx.Response.Body()), `durian_requests_total{server=":80",location="-",method="POST",status="201"} 1`)
+}
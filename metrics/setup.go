@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/caibirdme/durian/upstream"
+	"github.com/mholt/caddy"
+	"github.com/valyala/fasthttp"
+)
+
+func init() {
+	caddy.RegisterPlugin(super.DirectiveMetrics, caddy.Plugin{
+		ServerType: super.FastHTTPServerType,
+		Action:     setup,
+	})
+}
+
+const (
+	defaultPath = "/metrics"
+	// noLocation is the location label of requests which don't match any location
+	noLocation = "-"
+)
+
+// Config is the metrics config of a server block
+type Config struct {
+	// Path serves the metrics
+	Path string
+	// Locations label the requests, the first matched one wins
+	Locations []Location
+	Buckets   []float64
+}
+
+type Location struct {
+	Label   string
+	Matcher super.LocationMatcher
+}
+
+func setup(c *caddy.Controller) error {
+	cfg, err := parseConfig(c)
+	if err != nil {
+		return err
+	}
+	registry, ok := c.Get(super.MetricsKey).(*Registry)
+	if !ok {
+		registry = NewRegistry(cfg.Buckets)
+		c.Set(super.MetricsKey, registry)
+	} else if cfg.Buckets != nil && !reflect.DeepEqual(cfg.Buckets, registry.buckets) {
+		// the directive is executed for each key of a server block, which sets the same buckets
+		return c.Err("[metrics] buckets are shared by all the servers and can only be set once")
+	}
+	srv := super.GetConfig(c)
+	srv.AddNamedMiddleware(super.MetricsMiddlewareName, newMiddleware(c, srv.Addr, cfg, registry))
+	return nil
+}
+
+func newMiddleware(c *caddy.Controller, server string, cfg *Config, registry *Registry) super.Middleware {
+	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			if string(ctx.Path()) == cfg.Path {
+				ctx.SetContentType("text/plain; version=0.0.4")
+				if _, err := registry.WriteTo(ctx, upstream.Groups(c)); err != nil {
+					ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+				}
+				return
+			}
+			// match before next, since the path may be rewritten
+			location := cfg.location(ctx)
+			start := time.Now()
+			next(ctx)
+			registry.Observe(server, location, ctx.Method(), ctx.Response.StatusCode(), time.Since(start))
+		}
+	}
+}
+
+func (cfg *Config) location(ctx *fasthttp.RequestCtx) string {
+	for _, lo := range cfg.Locations {
+		if lo.Matcher.Match(ctx) {
+			return lo.Label
+		}
+	}
+	return noLocation
+}
+
+//	metrics [path] {
+//	    location /api
+//	    location ~ ^/user/\d+$
+//	    buckets 0.01 0.1 1
+//	}
+func parseConfig(c *caddy.Controller) (*Config, error) {
+	c.Next()
+	cfg := &Config{Path: defaultPath}
+	switch args := c.RemainingArgs(); len(args) {
+	case 0:
+	case 1:
+		cfg.Path = args[0]
+	default:
+		return nil, c.ArgErr()
+	}
+	for c.NextBlock() {
+		switch c.Val() {
+		case "location":
+			args := c.RemainingArgs()
+			matcher, err := super.NewMatcher(c, args)
+			if err != nil {
+				return nil, c.Err(err.Error())
+			}
+			cfg.Locations = append(cfg.Locations, Location{Label: args[len(args)-1], Matcher: matcher})
+		case "buckets":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.ArgErr()
+			}
+			buckets := make([]float64, 0, len(args))
+			for _, arg := range args {
+				b, err := strconv.ParseFloat(arg, 64)
+				if err != nil || b <= 0 {
+					return nil, c.Errf("[metrics] invalid bucket %s", arg)
+				}
+				buckets = append(buckets, b)
+			}
+			if !sort.Float64sAreSorted(buckets) {
+				return nil, c.Err("[metrics] buckets must be in increasing order")
+			}
+			cfg.Buckets = buckets
+		default:
+			return nil, c.Errf("[metrics] unknown option %s", c.Val())
+		}
+	}
+	return cfg, nil
+}
@@ -59,7 +59,7 @@ func setup(c *caddy.Controller) error {
 func parseConfig(c *caddy.Controller) (*NotFoundConfig, error) {
 	c.Next()
 
-	cfg := NotFoundConfig{StatusCode: defaultStatusCode, Body: defaultBody, ContentType: defaultContentType}
+	cfg := NotFoundConfig{StatusCode: defaultStatusCode, ContentType: defaultContentType}
 	for c.NextBlock() {
 		kind := c.Val()
 		switch strings.ToLower(kind) {
@@ -92,5 +92,8 @@ func parseConfig(c *caddy.Controller) (*NotFoundConfig, error) {
 	if cfg.File != "" && cfg.Body != "" {
 		return nil, c.Err("cannot specify file and body at the same time")
 	}
+	if cfg.File == "" && cfg.Body == "" {
+		cfg.Body = defaultBody
+	}
 	return &cfg, nil
 }
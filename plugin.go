@@ -3,17 +3,24 @@ package durian
 import (
 	"github.com/caibirdme/durian/server"
 	// plug in directives
+	_ "github.com/caibirdme/durian/error_page"
 	_ "github.com/caibirdme/durian/fastcgi"
 	_ "github.com/caibirdme/durian/gzip"
 	_ "github.com/caibirdme/durian/header"
+	_ "github.com/caibirdme/durian/location"
 	_ "github.com/caibirdme/durian/log"
+	_ "github.com/caibirdme/durian/matcher"
+	_ "github.com/caibirdme/durian/metrics"
 	_ "github.com/caibirdme/durian/not_found"
+	_ "github.com/caibirdme/durian/request_id"
 	_ "github.com/caibirdme/durian/response"
 	_ "github.com/caibirdme/durian/reverse_proxy"
 	_ "github.com/caibirdme/durian/rewrite"
+	_ "github.com/caibirdme/durian/server_options"
 	_ "github.com/caibirdme/durian/static"
 	_ "github.com/caibirdme/durian/status"
 	_ "github.com/caibirdme/durian/timeout"
+	_ "github.com/caibirdme/durian/tls"
 	_ "github.com/caibirdme/durian/upstream"
 	"github.com/mholt/caddy"
 	"io/ioutil"
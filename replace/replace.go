@@ -70,6 +70,11 @@ func ReplaceVariable(ctx *fasthttp.RequestCtx, w io.Writer, tag string) (int, er
 
 type ReplaceFunc func(ctx *fasthttp.RequestCtx, w io.Writer) (int, error)
 
+// RegisterPlaceholder makes {name} available to all the templates, plugins should call it in init
+func RegisterPlaceholder(name string, f ReplaceFunc) {
+	placeHolders[name] = f
+}
+
 var placeHolders = map[string]ReplaceFunc{
 	"host":         hostPlacer,
 	"hostonly":     hostonlyPlacer,
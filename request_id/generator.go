@@ -0,0 +1,139 @@
+package request_id
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	GeneratorUUID      = "uuid"
+	GeneratorULID      = "ulid"
+	GeneratorSnowflake = "snowflake"
+)
+
+// Generator generates unique request ids
+type Generator interface {
+	Generate() []byte
+}
+
+// NewGenerator returns the generator of the given kind, nodeID is only used by snowflake
+func NewGenerator(kind string, nodeID int64) (Generator, error) {
+	switch kind {
+	case GeneratorUUID:
+		return uuidGenerator{}, nil
+	case GeneratorULID:
+		return ulidGenerator{}, nil
+	case GeneratorSnowflake:
+		if nodeID < 0 || nodeID > snowflakeMaxNode {
+			return nil, fmt.Errorf("node_id should be in [0, %d]", snowflakeMaxNode)
+		}
+		return &snowflakeGenerator{node: nodeID}, nil
+	default:
+		return nil, fmt.Errorf("unknown request id generator %s", kind)
+	}
+}
+
+// uuidGenerator generates random UUIDs(version 4)
+type uuidGenerator struct{}
+
+func (uuidGenerator) Generate() []byte {
+	var u [16]byte
+	randomBytes(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return buf
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidGenerator generates ULIDs, which are sortable by time, see https://github.com/ulid/spec
+type ulidGenerator struct{}
+
+func (ulidGenerator) Generate() []byte {
+	var u [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	u[0], u[1], u[2] = byte(ms>>40), byte(ms>>32), byte(ms>>24)
+	u[3], u[4], u[5] = byte(ms>>16), byte(ms>>8), byte(ms)
+	randomBytes(u[6:])
+	// 128 bits are encoded into 26 characters, 5 bits each, the first one only has 3 bits
+	hi, lo := binary.BigEndian.Uint64(u[:8]), binary.BigEndian.Uint64(u[8:])
+	buf := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return buf
+}
+
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	snowflakeMaxNode  = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSeq   = 1<<snowflakeSeqBits - 1
+)
+
+// snowflakeEpoch is 2019-01-01T00:00:00Z in milliseconds
+var snowflakeEpoch = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+
+// snowflakeGenerator generates 63-bit ids composed of milliseconds, node id and sequence
+type snowflakeGenerator struct {
+	node int64
+	mu   sync.Mutex
+	last int64
+	seq  int64
+}
+
+func (g *snowflakeGenerator) Generate() []byte {
+	g.mu.Lock()
+	now := time.Now().UnixNano()/int64(time.Millisecond) - snowflakeEpoch
+	if now < g.last {
+		// the clock goes backwards, keep using the last timestamp
+		now = g.last
+	}
+	if now == g.last {
+		g.seq = (g.seq + 1) & snowflakeMaxSeq
+		if g.seq == 0 {
+			// run out of sequence in this millisecond, borrow the next one
+			now++
+		}
+	} else {
+		g.seq = 0
+	}
+	g.last = now
+	id := now<<(snowflakeNodeBits+snowflakeSeqBits) | g.node<<snowflakeSeqBits | g.seq
+	g.mu.Unlock()
+	return strconv.AppendInt(nil, id, 10)
+}
+
+// defaultNodeID derives the snowflake node id from hostname
+func defaultNodeID() int64 {
+	h := fnv.New32a()
+	name, _ := os.Hostname()
+	h.Write([]byte(name))
+	return int64(h.Sum32() & snowflakeMaxNode)
+}
+
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand never fails on supported platforms, fall back to the clock anyway
+		binary.BigEndian.PutUint64(b[len(b)-8:], uint64(time.Now().UnixNano()))
+	}
+}
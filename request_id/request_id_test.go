@@ -0,0 +1,92 @@
+package request_id
+
+import (
+	"net"
+	"regexp"
+	"testing"
+
+	"github.com/caibirdme/durian/replace"
+	super "github.com/caibirdme/durian/server"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestGenerators(t *testing.T) {
+	should := require.New(t)
+	var testData = []struct {
+		kind    string
+		pattern string
+	}{
+		{GeneratorUUID, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{GeneratorULID, `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`},
+		{GeneratorSnowflake, `^[0-9]+$`},
+	}
+	for _, tc := range testData {
+		gen, err := NewGenerator(tc.kind, 1)
+		should.NoError(err)
+		re := regexp.MustCompile(tc.pattern)
+		seen := make(map[string]bool)
+		last := ""
+		for i := 0; i < 10000; i++ {
+			id := string(gen.Generate())
+			should.Regexp(re, id, tc.kind)
+			should.False(seen[id], "%s generates duplicated id %s", tc.kind, id)
+			seen[id] = true
+			if tc.kind == GeneratorSnowflake {
+				// snowflake ids increase monotonically
+				should.True(len(id) > len(last) || (len(id) == len(last) && id > last))
+				last = id
+			}
+		}
+	}
+	_, err := NewGenerator(GeneratorSnowflake, snowflakeMaxNode+1)
+	should.Error(err)
+	_, err = NewGenerator("foo", 0)
+	should.Error(err)
+}
+
+func TestMiddleware(t *testing.T) {
+	should := require.New(t)
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	cfg := &Config{
+		Header:         defaultHeaderName,
+		Trusted:        []*net.IPNet{trusted},
+		ResponseHeader: true,
+	}
+	gen, _ := NewGenerator(GeneratorUUID, 0)
+	vp := replace.NewVariablePlaceholder()
+	vp.SetTmpl("id={request_id}")
+	var upstreamID, placeholder string
+	handler := newMiddleware(cfg, gen)(func(ctx *fasthttp.RequestCtx) {
+		upstreamID = string(ctx.Request.Header.Peek(defaultHeaderName))
+		placeholder, _ = vp.ExecuteString("id={request_id}", ctx)
+		// upstreams may replace the whole response
+		ctx.Response.Reset()
+	})
+
+	serve := func(ip string, incoming string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.ParseIP(ip)}, nil)
+		if incoming != "" {
+			ctx.Request.Header.Set(defaultHeaderName, incoming)
+		}
+		handler(ctx)
+		return ctx
+	}
+
+	ctx := serve("10.1.2.3", "")
+	should.Len(upstreamID, 36)
+	should.Equal(upstreamID, string(ctx.Response.Header.Peek(defaultHeaderName)))
+	should.Equal(upstreamID, string(super.GetRequestID(ctx)))
+	should.Equal("id="+upstreamID, placeholder)
+
+	ctx = serve("10.1.2.3", "from-lb")
+	should.Equal("from-lb", upstreamID)
+	should.Equal("from-lb", string(ctx.Response.Header.Peek(defaultHeaderName)))
+
+	// untrusted clients and invalid ids get a new one
+	serve("192.168.1.1", "from-client")
+	should.Len(upstreamID, 36)
+	serve("10.1.2.3", "bad\tid")
+	should.Len(upstreamID, 36)
+}
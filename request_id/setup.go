@@ -0,0 +1,170 @@
+package request_id
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/caibirdme/durian/replace"
+	super "github.com/caibirdme/durian/server"
+	"github.com/mholt/caddy"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	pluginName        = "request_id"
+	defaultHeaderName = "X-Request-Id"
+	// incoming ids longer than it are dropped
+	maxIncomingLength = 128
+)
+
+func init() {
+	caddy.RegisterPlugin(super.DirectiveRequestID, caddy.Plugin{
+		ServerType: super.FastHTTPServerType,
+		Action:     setup,
+	})
+	replace.RegisterPlaceholder("request_id", func(ctx *fasthttp.RequestCtx, w io.Writer) (int, error) {
+		return w.Write(super.GetRequestID(ctx))
+	})
+}
+
+type Config struct {
+	Generator string
+	NodeID    int64
+	Header    string
+	// Trusted is the networks whose incoming request id is reused, TrustAll trusts everyone
+	Trusted        []*net.IPNet
+	TrustAll       bool
+	ResponseHeader bool
+}
+
+func setup(c *caddy.Controller) error {
+	cfg, err := parseConfig(c)
+	if err != nil {
+		return err
+	}
+	gen, err := NewGenerator(cfg.Generator, cfg.NodeID)
+	if err != nil {
+		return c.Errf("[%s] %s", pluginName, err)
+	}
+	srvCfg := super.GetConfig(c)
+	srvCfg.RequestIDName = cfg.Header
+	srvCfg.AddNamedMiddleware(super.UUIDMiddlewareName, newMiddleware(cfg, gen))
+	return nil
+}
+
+// newMiddleware sets the request id as a request header, so that it's passed to proxy and fastcgi upstreams
+func newMiddleware(cfg *Config, gen Generator) super.Middleware {
+	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			id := ctx.Request.Header.Peek(cfg.Header)
+			if len(id) == 0 || !cfg.trusted(ctx) || !validID(id) {
+				ctx.Request.Header.SetBytesV(cfg.Header, gen.Generate())
+			}
+			ctx.SetUserValue(super.RequestIDHeaderName, cfg.Header)
+			next(ctx)
+			// the response may be replaced by upstreams, so the header is set at last
+			if cfg.ResponseHeader {
+				ctx.Response.Header.SetBytesV(cfg.Header, ctx.Request.Header.Peek(cfg.Header))
+			}
+		}
+	}
+}
+
+func (cfg *Config) trusted(ctx *fasthttp.RequestCtx) bool {
+	if cfg.TrustAll {
+		return true
+	}
+	ip := ctx.RemoteIP()
+	for _, n := range cfg.Trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validID only accepts printable ascii, so that ids can't break logs or headers
+func validID(id []byte) bool {
+	if len(id) > maxIncomingLength {
+		return false
+	}
+	for _, b := range id {
+		if b <= ' ' || b > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+//	request_id [uuid|ulid|snowflake] {
+//	    header X-Request-Id
+//	    trust 10.0.0.0/8 127.0.0.1
+//	    node_id 1
+//	    response_header off
+//	}
+func parseConfig(c *caddy.Controller) (*Config, error) {
+	c.Next()
+	cfg := Config{
+		Generator:      GeneratorUUID,
+		NodeID:         defaultNodeID(),
+		Header:         defaultHeaderName,
+		ResponseHeader: true,
+	}
+	if c.NextArg() {
+		cfg.Generator = strings.ToLower(c.Val())
+	}
+	for c.NextBlock() {
+		kind := c.Val()
+		args := c.RemainingArgs()
+		switch strings.ToLower(kind) {
+		case "header":
+			if len(args) != 1 {
+				return nil, c.ArgErr()
+			}
+			cfg.Header = args[0]
+		case "trust":
+			if len(args) == 0 {
+				cfg.TrustAll = true
+			}
+			for _, arg := range args {
+				n, err := parseNetwork(arg)
+				if err != nil {
+					return nil, c.Errf("[%s] invalid trusted network %s", pluginName, arg)
+				}
+				cfg.Trusted = append(cfg.Trusted, n)
+			}
+		case "node_id":
+			if len(args) != 1 {
+				return nil, c.ArgErr()
+			}
+			n, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return nil, c.Errf("[%s] node_id must be int but %s", pluginName, args[0])
+			}
+			cfg.NodeID = n
+		case "response_header":
+			if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+				return nil, c.Errf("[%s] response_header should be on or off", pluginName)
+			}
+			cfg.ResponseHeader = args[0] == "on"
+		default:
+			return nil, c.Errf("[%s] unknown option %s", pluginName, kind)
+		}
+	}
+	return &cfg, nil
+}
+
+// parseNetwork accepts both CIDR and a single IP
+func parseNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+			s += "/32"
+		} else {
+			s += "/128"
+		}
+	}
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
@@ -38,7 +38,7 @@ func setup(c *caddy.Controller) error {
 	h := header.NewHeaderSetter(cfg.Headers)
 	super.GetConfig(c).AddMiddleware(func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 		return func(ctx *fasthttp.RequestCtx) {
-			if cfg.location.Match(ctx.Path()) {
+			if cfg.location.Match(ctx) {
 				if err := h.Set(ctx); err != nil {
 					// todo: log
 				}
@@ -65,7 +65,7 @@ func parseCfg(c *caddy.Controller) (*RespConfig, error) {
 		ContentType: defaultContentType,
 	}
 	firstLie := c.RemainingArgs()
-	location, err := super.NewLocationMatcher(firstLie)
+	location, err := super.NewMatcher(c, firstLie)
 	if err != nil {
 		return nil, err
 	}
@@ -1,42 +1,88 @@
 package reverse_proxy
 
 import (
+	"net"
 	"strings"
 	"time"
 
 	super "github.com/caibirdme/durian/server"
+	"github.com/caibirdme/durian/upstream"
 	"github.com/valyala/fasthttp"
 )
 
 type Proxy struct {
 	next             fasthttp.RequestHandler
-	client           *fasthttp.HostClient
+	group            *upstream.Group
+	balancer         upstream.Balancer
+	clients          map[*upstream.Peer]*fasthttp.HostClient
 	location         super.LocationMatcher
 	timeout          time.Duration
+	retry            upstream.RetryPolicy
 	headerUpstream   []super.KVTuple
 	headerDownstream []super.KVTuple
 }
 
 func NewProxy(cfg ProxyConfig) (*Proxy, error) {
-	addr := strings.Join(cfg.AddressList, ",")
-	client := &fasthttp.HostClient{
-		Addr: addr,
+	group := cfg.Upstream
+	if group == nil {
+		// addresses listed in proxy's own upstream block
+		u := super.Upstream{Name: strings.Join(cfg.AddressList, ",")}
+		for _, addr := range cfg.AddressList {
+			u.Backends = append(u.Backends, super.Backend{
+				Network:     "tcp",
+				Addr:        addr,
+				Weight:      1,
+				MaxFails:    upstream.DefaultMaxFails,
+				FailTimeout: upstream.DefaultFailTimeout,
+			})
+		}
+		group = upstream.NewGroup(u)
+	}
+	balancer, err := upstream.NewBalancer(cfg.Policy, cfg.PolicyArgs)
+	if err != nil {
+		return nil, err
 	}
-	if cfg.MaxConn > 0 {
-		client.SetMaxConns(cfg.MaxConn)
+	clients := make(map[*upstream.Peer]*fasthttp.HostClient)
+	for _, peer := range group.Peers() {
+		clients[peer] = newHostClient(peer.Backend, cfg.MaxConn)
 	}
 	return &Proxy{
 		location:         cfg.location,
-		client:           client,
+		group:            group,
+		balancer:         balancer,
+		clients:          clients,
 		timeout:          cfg.Timeout,
+		retry:            cfg.Retry,
 		headerUpstream:   cfg.UpstreamHeader,
 		headerDownstream: cfg.DownstreamHeader,
 	}, nil
 }
 
+// Group returns the backends of the proxy
+func (p *Proxy) Group() *upstream.Group {
+	return p.group
+}
+
+func newHostClient(b super.Backend, maxConn int) *fasthttp.HostClient {
+	dial := fasthttp.Dial
+	if b.Network == "unix" {
+		dial = func(addr string) (net.Conn, error) {
+			return net.Dial("unix", addr)
+		}
+	}
+	client := &fasthttp.HostClient{
+		Addr: b.Addr,
+		Dial: timedDial(dial),
+	}
+	if maxConn > 0 {
+		client.MaxConns = maxConn
+	}
+	return client
+}
+
 func (p *Proxy) Handle(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(reqCtx *fasthttp.RequestCtx) {
-		if !p.location.Match(reqCtx.Path()) {
+		if !p.location.Match(reqCtx) {
 			next(reqCtx)
 			return
 		}
@@ -44,16 +90,82 @@ func (p *Proxy) Handle(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 			reqCtx.Request.Header.Set(tuple.K, tuple.V)
 		}
 
-		err := p.client.DoTimeout(&reqCtx.Request, &reqCtx.Response, p.timeout)
-		if err != nil {
-			if err == fasthttp.ErrTimeout {
-				reqCtx.TimeoutError(err.Error())
+		p.forward(reqCtx)
+		for _, tuple := range p.headerDownstream {
+			reqCtx.Response.Header.Set(tuple.K, tuple.V)
+		}
+	}
+}
+
+// forward passes the request to backends until it succeeds or the retry policy gives up
+func (p *Proxy) forward(reqCtx *fasthttp.RequestCtx) {
+	start := time.Now()
+	var (
+		tried []*upstream.Peer
+		err   error
+	)
+	for {
+		peer := p.balancer.Pick(reqCtx, p.group.Untried(tried))
+		if peer == nil {
+			if len(tried) == 0 {
+				reqCtx.Error("no available upstream", fasthttp.StatusBadGateway)
 			} else {
-				reqCtx.Error(err.Error(), fasthttp.StatusServiceUnavailable)
+				// keep the response of the last try
+				p.writeError(reqCtx, err)
 			}
+			return
 		}
-		for _, tuple := range p.headerDownstream {
-			reqCtx.Response.Header.Set(tuple.K, tuple.V)
+		if len(tried) > 0 {
+			reqCtx.Response.Reset()
+		}
+		tried = append(tried, peer)
+		peer.Acquire()
+		tryStart := time.Now()
+		err = p.clients[peer].DoTimeout(&reqCtx.Request, &reqCtx.Response, p.retry.NextTimeout(p.timeout, start))
+		peer.Release()
+		try := super.UpstreamTry{Addr: peer.String(), ConnectTime: -1, HeaderTime: -1, ResponseTime: time.Since(tryStart)}
+		if err == nil {
+			try.Status = reqCtx.Response.StatusCode()
+			try.ConnectTime, try.HeaderTime = responseTiming(&reqCtx.Response, tryStart)
 		}
+		super.AddUpstreamTry(reqCtx, try)
+		cond, failed := upstream.RetryOnError, true
+		if err == fasthttp.ErrTimeout {
+			cond = upstream.RetryOnTimeout
+		} else if err == nil {
+			cond, failed = p.retry.FailedStatus(reqCtx.Response.StatusCode())
+		}
+		if !failed {
+			peer.MarkSuccess()
+			return
+		}
+		peer.MarkFailure()
+		if !p.retry.ShouldRetry(cond, reqCtx.Method(), requestSent(err), len(tried), start) {
+			p.writeError(reqCtx, err)
+			return
+		}
+	}
+}
+
+// requestSent reports whether the request may have reached the backend, it hasn't if the connection isn't established
+func requestSent(err error) bool {
+	switch err {
+	case nil:
+		return true
+	case fasthttp.ErrDialTimeout, fasthttp.ErrNoFreeConns:
+		return false
+	}
+	if op, ok := err.(*net.OpError); ok && op.Op == "dial" {
+		return false
+	}
+	return true
+}
+
+// writeError responds with err if there's no response from backend
+func (p *Proxy) writeError(reqCtx *fasthttp.RequestCtx, err error) {
+	if err == fasthttp.ErrTimeout {
+		reqCtx.TimeoutError(err.Error())
+	} else if err != nil {
+		reqCtx.Error(err.Error(), fasthttp.StatusServiceUnavailable)
 	}
 }
@@ -0,0 +1,151 @@
+package reverse_proxy
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caibirdme/durian/metrics"
+	super "github.com/caibirdme/durian/server"
+	"github.com/caibirdme/durian/upstream"
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyfile"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func newTestProxy(t *testing.T, addrs ...string) *Proxy {
+	location, err := super.NewLocationMatcher([]string{"/"})
+	require.NoError(t, err)
+	p, err := NewProxy(ProxyConfig{
+		location:    location,
+		AddressList: addrs,
+		Policy:      upstream.PolicyRoundRobin,
+		Timeout:     time.Second,
+		Retry:       upstream.DefaultRetryPolicy(),
+	})
+	require.NoError(t, err)
+	return p
+}
+
+func serve(p *Proxy) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("http://a.com/")
+	p.Handle(nil)(ctx)
+	return ctx
+}
+
+func TestProxy_SingleBackendNotEjected(t *testing.T) {
+	should := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	addr := ln.Addr().String()
+	should.NoError(ln.Close())
+
+	p := newTestProxy(t, addr)
+	// the backend isn't up yet
+	should.Equal(fasthttp.StatusServiceUnavailable, serve(p).Response.StatusCode())
+	should.Equal(uint64(1), p.Group().Primary[0].Failures())
+	should.True(p.Group().Primary[0].Available())
+
+	ln, err = net.Listen("tcp", addr)
+	should.NoError(err)
+	defer ln.Close()
+	go fasthttp.Serve(ln, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString("ok")
+	})
+	ctx := serve(p)
+	should.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+	should.Equal("ok", string(ctx.Response.Body()))
+}
+
+func TestSetup_TrackGroups(t *testing.T) {
+	should := require.New(t)
+	input := "proxy / {\n upstream {\n 127.0.0.1:9001\n 127.0.0.1:9002\n }\n}"
+	c := caddy.NewTestController(super.FastHTTPServerType, input)
+	sblocks, err := caddyfile.Parse("Testfile", strings.NewReader(":8080, :8081 {\n"+input+"\n}"), nil)
+	should.NoError(err)
+	_, err = c.Context().InspectServerBlocks("Testfile", sblocks)
+	should.NoError(err)
+	// directives are executed once per key
+	for _, key := range sblocks[0].Keys {
+		c.Key = key
+		c.Dispenser = caddyfile.NewDispenser("Testfile", strings.NewReader(input))
+		should.NoError(setup(c))
+	}
+	groups := upstream.Groups(c)
+	should.Len(groups, 2)
+	should.Equal(":8080/proxy#0", groups[0].Name)
+	should.Equal(":8081/proxy#0", groups[1].Name)
+
+	var buf bytes.Buffer
+	_, err = metrics.NewRegistry(nil).WriteTo(&buf, groups)
+	should.NoError(err)
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "durian_upstream_") {
+			series := line[:strings.LastIndexByte(line, ' ')]
+			should.False(seen[series], series)
+			seen[series] = true
+		}
+	}
+	should.Len(seen, 4*4)
+}
+
+func TestProxy_RetryPostOnDialError(t *testing.T) {
+	should := require.New(t)
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	deadAddr := dead.Addr().String()
+	should.NoError(dead.Close())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	defer ln.Close()
+	go fasthttp.Serve(ln, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBody(ctx.PostBody())
+	})
+
+	p := newTestProxy(t, deadAddr, ln.Addr().String())
+	p.retry.Tries = 2
+	// round robin may start from either of them
+	retried := false
+	for i := 0; i < 2; i++ {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("http://a.com/")
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.SetBodyString("data")
+		p.Handle(nil)(ctx)
+		should.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+		should.Equal("data", string(ctx.Response.Body()))
+		retried = retried || len(super.GetUpstreamTries(ctx)) == 2
+	}
+	should.True(retried)
+}
+
+func TestProxy_UpstreamTiming(t *testing.T) {
+	should := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	defer ln.Close()
+	go fasthttp.Serve(ln, func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(20 * time.Millisecond)
+		ctx.SetBodyString("ok")
+	})
+	p := newTestProxy(t, ln.Addr().String())
+	for i := 0; i < 2; i++ {
+		tries := super.GetUpstreamTries(serve(p))
+		should.Len(tries, 1)
+		try := tries[0]
+		should.Equal(fasthttp.StatusOK, try.Status)
+		if i == 0 {
+			should.True(try.ConnectTime > 0)
+		} else {
+			// the keepalive connection is reused
+			should.Equal(time.Duration(0), try.ConnectTime)
+		}
+		should.True(try.HeaderTime >= 20*time.Millisecond+try.ConnectTime, try.HeaderTime)
+		should.True(try.ResponseTime >= try.HeaderTime)
+	}
+}
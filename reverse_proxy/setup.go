@@ -6,6 +6,7 @@ import (
 	"time"
 
 	super "github.com/caibirdme/durian/server"
+	"github.com/caibirdme/durian/upstream"
 	"github.com/mholt/caddy"
 )
 
@@ -29,17 +30,25 @@ func setup(c *caddy.Controller) error {
 	if nil != err {
 		return err
 	}
+	if cfg.Upstream == nil {
+		upstream.TrackGroup(c, super.DirectiveProxy, p.Group())
+	}
 	super.GetConfig(c).AddMiddleware(p.Handle)
 	return nil
 }
 
 type ProxyConfig struct {
-	location         super.LocationMatcher
+	location super.LocationMatcher
+	// Upstream is set if proxy refers a named upstream, otherwise AddressList is used
+	Upstream         *upstream.Group
 	AddressList      []string
+	Policy           string
+	PolicyArgs       []string
 	UpstreamHeader   []super.KVTuple
 	DownstreamHeader []super.KVTuple
 	Timeout          time.Duration
 	MaxConn          int
+	Retry            upstream.RetryPolicy
 }
 
 var (
@@ -48,9 +57,13 @@ var (
 
 func parseProxy(c *caddy.Controller) (*ProxyConfig, error) {
 	c.Next()
-	cfg := ProxyConfig{Timeout: defaultTimeout}
+	cfg := ProxyConfig{
+		Timeout: defaultTimeout,
+		Policy:  upstream.PolicyRoundRobin,
+		Retry:   upstream.DefaultRetryPolicy(),
+	}
 	firstLine := c.RemainingArgs()
-	location, err := super.NewLocationMatcher(firstLine)
+	location, err := super.NewMatcher(c, firstLine)
 	if err != nil {
 		return nil, err
 	}
@@ -62,6 +75,9 @@ func parseProxy(c *caddy.Controller) (*ProxyConfig, error) {
 			return nil, err
 		}
 	}
+	if cfg.Upstream == nil && len(cfg.AddressList) == 0 {
+		return nil, c.Errf("[%s] upstream is required", pluginName)
+	}
 	return &cfg, nil
 }
 
@@ -92,6 +108,15 @@ func parseKind(kind string, cfg *ProxyConfig, c *caddy.Controller) error {
 		if nil != err {
 			return err
 		}
+	case "policy":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.Policy = strings.ToLower(c.Val())
+		cfg.PolicyArgs = c.RemainingArgs()
+		if _, err := upstream.NewBalancer(cfg.Policy, cfg.PolicyArgs); err != nil {
+			return c.Errf("[%s] %s", pluginName, err)
+		}
 	case "max_conn":
 		if !c.NextArg() {
 			return c.Err("need path value")
@@ -102,7 +127,13 @@ func parseKind(kind string, cfg *ProxyConfig, c *caddy.Controller) error {
 		}
 		cfg.MaxConn = max_conn
 	default:
-		return c.Errf("[%s] illegal directive %s", pluginName, kind)
+		handled, err := cfg.Retry.ParseOption(strings.ToLower(kind), c.RemainingArgs())
+		if err != nil {
+			return c.Errf("[%s] %s", pluginName, err)
+		}
+		if !handled {
+			return c.Errf("[%s] illegal directive %s", pluginName, kind)
+		}
 	}
 	return nil
 }
@@ -116,12 +147,27 @@ func parseKVTuple(c *caddy.Controller, cfg *[]super.KVTuple) error {
 	return nil
 }
 
+// upstream name
+//
+//	upstream {
+//	    addr1
+//	    addr2
+//	}
+//
 // due to bug of Dispender, this is a workaround
 func parseUpstream(c *caddy.Controller, cfg *ProxyConfig) error {
-	// {
-	if !c.Next() {
+	if !c.NextArg() {
 		return c.ArgErr()
 	}
+	if c.Val() != "{" {
+		name := c.Val()
+		g, ok := upstream.GetGroup(c, name)
+		if !ok {
+			return c.Errf("[%s] invalid upstream name %s", pluginName, name)
+		}
+		cfg.Upstream = g
+		return nil
+	}
 	for c.Next() {
 		address := c.Val()
 		if address == "}" {
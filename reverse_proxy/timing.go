@@ -0,0 +1,72 @@
+package reverse_proxy
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// timedDial wraps dial so that connections record the timing of the requests on them
+func timedDial(dial fasthttp.DialFunc) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		return &timedConn{Conn: conn, dial: time.Since(start)}, nil
+	}
+}
+
+// timedConn passes the timing of requests through the net.Addr returned by LocalAddr,
+// since fasthttp.HostClient calls it once a request acquires the connection, and keeps it in the response
+type timedConn struct {
+	net.Conn
+	dial time.Duration
+	used bool
+	// cur is the timing of the request holding the connection
+	cur *tryTiming
+}
+
+// tryTiming is the local address of the connection with the timing of a request on it
+type tryTiming struct {
+	net.Addr
+	// connect is the time spent on dialing, 0 if the connection is reused
+	connect time.Duration
+	// firstByte is the unix nano when the first byte of response is read
+	firstByte int64
+}
+
+func (c *timedConn) LocalAddr() net.Addr {
+	t := &tryTiming{Addr: c.Conn.LocalAddr()}
+	// the new connection is used by the request dialing it at first
+	if !c.used {
+		c.used = true
+		t.connect = c.dial
+	}
+	c.cur = t
+	return t
+}
+
+func (c *timedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if t := c.cur; n > 0 && t != nil && atomic.LoadInt64(&t.firstByte) == 0 {
+		atomic.StoreInt64(&t.firstByte, time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// responseTiming returns the connect and header time of the response read since start, -1 if it's unknown
+func responseTiming(resp *fasthttp.Response, start time.Time) (connect, header time.Duration) {
+	t, ok := resp.LocalAddr().(*tryTiming)
+	if !ok {
+		return -1, -1
+	}
+	header = -1
+	if firstByte := atomic.LoadInt64(&t.firstByte); firstByte > 0 {
+		header = time.Duration(firstByte - start.UnixNano())
+	}
+	return t.connect, header
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/valyala/fasthttp"
+)
+
+// StreamRequestBody lets the requests matching location be handled before their bodies are read, such as fastcgi does.
+// Bodies of other requests are read into memory up to MaxRequestBodySize as usual
+func (cfg *ServerConfig) StreamRequestBody(location LocationMatcher) {
+	cfg.streamLocations = append(cfg.streamLocations, location)
+}
+
+// prefetchedBodySize is the most fasthttp reads of a streamed body before calling handlers
+const prefetchedBodySize = 8 << 10
+
+// streamsRequestBody reports whether the body of the request is passed to handlers as a stream.
+// Bodies of unknown length are always read since backends need the length before the body,
+// and so are the ones fasthttp has read
+func (cfg *ServerConfig) streamsRequestBody(ctx *fasthttp.RequestCtx) bool {
+	if n := ctx.Request.Header.ContentLength(); n < 0 || n <= prefetchedBodySize {
+		return false
+	}
+	for _, lo := range cfg.streamLocations {
+		if lo.Match(ctx) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRequestBody is used with fasthttp.Server.StreamRequestBody, which passes all the bodies as streams.
+// It reads the bodies which aren't streamed like fasthttp does without streaming,
+// and discards the unread part of streamed ones before the next request on the connection
+func (cfg *ServerConfig) withRequestBody(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	limit := cfg.MaxRequestBodySize
+	if limit <= 0 {
+		limit = fasthttp.DefaultMaxRequestBodySize
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		if ctx.Request.IsBodyStream() && !cfg.streamsRequestBody(ctx) {
+			if err := readRequestBody(ctx, limit); err != nil {
+				if err == fasthttp.ErrBodyTooLarge {
+					ctx.Error(fasthttp.StatusMessage(fasthttp.StatusRequestEntityTooLarge), fasthttp.StatusRequestEntityTooLarge)
+				} else {
+					ctx.Error(fasthttp.StatusMessage(fasthttp.StatusBadRequest), fasthttp.StatusBadRequest)
+				}
+				// the rest of the body is left on the connection
+				ctx.SetConnectionClose()
+				return
+			}
+		}
+		next(ctx)
+		discardRequestBody(ctx)
+	}
+}
+
+func readRequestBody(ctx *fasthttp.RequestCtx, limit int) error {
+	if ctx.Request.Header.ContentLength() > limit {
+		return fasthttp.ErrBodyTooLarge
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(ctx.RequestBodyStream(), int64(limit)+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > limit {
+		return fasthttp.ErrBodyTooLarge
+	}
+	ctx.Request.SetBodyRaw(body)
+	return nil
+}
+
+// discardRequestBody reads the rest of the streamed request body, fasthttp doesn't do it
+func discardRequestBody(ctx *fasthttp.RequestCtx) {
+	if !ctx.Request.IsBodyStream() {
+		return
+	}
+	if _, err := io.Copy(ioutil.Discard, ctx.RequestBodyStream()); err != nil {
+		ctx.SetConnectionClose()
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestRequestBody_Stream(t *testing.T) {
+	should := require.New(t)
+	c, err := inspect(t, ":8080 {\n}")
+	should.NoError(err)
+	cfg := &c.cfg[0]
+	cfg.MaxRequestBodySize = 16 << 10
+	upload, err := NewLocationMatcher([]string{"/upload"})
+	should.NoError(err)
+	cfg.StreamRequestBody(upload)
+	cfg.AddMiddleware(func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			streamed := ctx.Request.IsBodyStream()
+			var body []byte
+			switch {
+			case string(ctx.Path()) == "/upload/ignored":
+			case streamed:
+				body, _ = ioutil.ReadAll(ctx.RequestBodyStream())
+			default:
+				body = ctx.Request.Body()
+			}
+			fmt.Fprintf(ctx, "%t %d", streamed, len(body))
+		}
+	})
+	servers, err := c.MakeServers()
+	should.NoError(err)
+	srv := servers[0].(*FastServer)
+	should.True(srv.StreamRequestBody)
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go srv.Serve(ln)
+	// requests are sent one by one on the same connection, so the unread bodies must be discarded by server
+	client := &fasthttp.HostClient{Addr: "a.com", MaxConns: 1, Dial: func(string) (net.Conn, error) {
+		return ln.Dial()
+	}}
+	do := func(path string, size int, chunked bool) (int, string) {
+		req, resp := &fasthttp.Request{}, &fasthttp.Response{}
+		req.SetRequestURI("http://a.com" + path)
+		req.Header.SetMethod("POST")
+		body := bytes.Repeat([]byte("a"), size)
+		if chunked {
+			req.SetBodyStream(bytes.NewReader(body), -1)
+		} else {
+			req.SetBody(body)
+		}
+		should.NoError(client.Do(req, resp))
+		return resp.StatusCode(), string(resp.Body())
+	}
+	for _, tc := range []struct {
+		path    string
+		size    int
+		chunked bool
+		status  int
+		body    string
+	}{
+		{path: "/upload", size: 1 << 20, status: 200, body: "true 1048576"},
+		{path: "/upload/ignored", size: 1 << 20, status: 200, body: "true 0"},
+		{path: "/upload", size: 100, status: 200, body: "false 100"},
+		// bodies of unknown length are read
+		{path: "/upload", size: 10 << 10, chunked: true, status: 200, body: "false 10240"},
+		{path: "/other", size: 10 << 10, status: 200, body: "false 10240"},
+		{path: "/other", size: 10 << 10, chunked: true, status: 200, body: "false 10240"},
+	} {
+		status, body := do(tc.path, tc.size, tc.chunked)
+		should.Equal(tc.status, status, tc.path)
+		should.Equal(tc.body, body, tc.path)
+	}
+
+	// the connection is closed with the rest of the body unread, the client may fail to write it
+	for _, chunked := range []bool{false, true} {
+		conn, err := ln.Dial()
+		should.NoError(err)
+		req := &fasthttp.Request{}
+		req.SetRequestURI("http://a.com/other")
+		req.Header.SetMethod("POST")
+		body := bytes.NewReader(make([]byte, 20<<10))
+		if chunked {
+			req.SetBodyStream(body, -1)
+		} else {
+			req.SetBodyStream(body, body.Len())
+		}
+		go func() {
+			w := bufio.NewWriter(conn)
+			if req.Write(w) == nil {
+				w.Flush()
+			}
+		}()
+		resp := &fasthttp.Response{}
+		should.NoError(resp.Read(bufio.NewReader(conn)))
+		should.Equal(fasthttp.StatusRequestEntityTooLarge, resp.StatusCode())
+		should.True(resp.ConnectionClose())
+		conn.Close()
+	}
+}
@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"github.com/mholt/caddy"
 	"github.com/valyala/fasthttp"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type KVTuple struct {
@@ -25,6 +30,12 @@ const (
 	UpstreamKey StorageKey = iota
 	ServerNameKey
 	DocRootKey
+	// UpstreamGroupKey stores runtime state of upstreams, which is shared by proxy and fastcgi
+	UpstreamGroupKey
+	// AnonymousGroupKey stores the upstreams defined inside proxy
+	AnonymousGroupKey
+	// MetricsKey stores the metrics shared by all the servers
+	MetricsKey
 )
 
 func GetStdCtx(reqCtx *fasthttp.RequestCtx) context.Context {
@@ -36,8 +47,9 @@ func GetStdCtx(reqCtx *fasthttp.RequestCtx) context.Context {
 }
 
 type Upstream struct {
-	Name     string
-	Backends []Backend
+	Name        string
+	Backends    []Backend
+	HealthCheck HealthCheck
 }
 
 type Backend struct {
@@ -45,6 +57,34 @@ type Backend struct {
 	Addr    string
 	Weight  int
 	Backup  bool
+	// MaxFails failures within FailTimeout make the backend unavailable for FailTimeout, 0 disables it
+	MaxFails    int
+	FailTimeout time.Duration
+}
+
+// String returns the address of backend, unix sockets are prefixed with "unix:" like nginx
+func (b Backend) String() string {
+	if b.Network == "unix" {
+		return "unix:" + b.Addr
+	}
+	return b.Addr
+}
+
+const (
+	HealthCheckHTTP    = "http"
+	HealthCheckTCP     = "tcp"
+	HealthCheckFastCGI = "fastcgi"
+)
+
+// HealthCheck configures the active probe of an upstream, Type is empty if it's disabled
+type HealthCheck struct {
+	Type     string
+	URI      string
+	Interval time.Duration
+	Timeout  time.Duration
+	// Rise consecutive successes mark a backend healthy, Fall consecutive failures mark it unhealthy
+	Rise int
+	Fall int
 }
 
 type location struct {
@@ -52,17 +92,20 @@ type location struct {
 	prefix  []byte
 }
 
-func (lo *location) Match(uri []byte) bool {
+func (lo *location) Match(ctx *fasthttp.RequestCtx) bool {
+	uri := ctx.Path()
 	if lo.pattern != nil {
 		return lo.pattern.Match(uri)
 	}
 	return bytes.HasPrefix(uri, lo.prefix)
 }
 
+// LocationMatcher reports whether the request should be handled by a directive
 type LocationMatcher interface {
-	Match(uri []byte) bool
+	Match(ctx *fasthttp.RequestCtx) bool
 }
 
+// NewLocationMatcher returns a matcher of path, firstLine is a prefix or `~ regexp`
 func NewLocationMatcher(firstLine []string) (LocationMatcher, error) {
 	if len(firstLine) == 0 {
 		return nil, errors.New("nil firstLine")
@@ -77,13 +120,26 @@ func NewLocationMatcher(firstLine []string) (LocationMatcher, error) {
 	return &location{pattern: re}, nil
 }
 
+// NewMatcher returns the named matcher if firstLine is `@name`, otherwise it's the same as NewLocationMatcher
+func NewMatcher(c *caddy.Controller, firstLine []string) (LocationMatcher, error) {
+	if len(firstLine) == 1 && strings.HasPrefix(firstLine[0], "@") {
+		if cfg := GetConfig(c); cfg != nil {
+			if m, ok := cfg.NamedMatcher(firstLine[0][1:]); ok {
+				return m, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown matcher %s", firstLine[0])
+	}
+	return NewLocationMatcher(firstLine)
+}
+
 type combineMather struct {
 	should  LocationMatcher
 	exclude LocationMatcher
 }
 
-func (c *combineMather) Match(uri []byte) bool {
-	if c.should.Match(uri) && !c.exclude.Match(uri) {
+func (c *combineMather) Match(ctx *fasthttp.RequestCtx) bool {
+	if c.should.Match(ctx) && !c.exclude.Match(ctx) {
 		return true
 	}
 	return false
@@ -92,3 +148,37 @@ func (c *combineMather) Match(uri []byte) bool {
 func NewCombineMatcher(shouldMatch LocationMatcher, exclude LocationMatcher) LocationMatcher {
 	return &combineMather{should: shouldMatch, exclude: exclude}
 }
+
+// GetRequestID returns the request id set by the uuid middleware, nil if there's none
+func GetRequestID(reqCtx *fasthttp.RequestCtx) []byte {
+	headerKey, ok := reqCtx.UserValue(RequestIDHeaderName).(string)
+	if !ok {
+		return nil
+	}
+	return reqCtx.Request.Header.Peek(headerKey)
+}
+
+// ParseSize parses size like 1024, 64k, 8m or 1g into bytes
+func ParseSize(s string) (int, error) {
+	s = strings.Trim(s, " ")
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+	unit := 1
+	switch s[len(s)-1] {
+	case 'k', 'K':
+		unit = 1024
+	case 'm', 'M':
+		unit = 1024 * 1024
+	case 'g', 'G':
+		unit = 1024 * 1024 * 1024
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	size, err := strconv.Atoi(s)
+	if nil != err {
+		return 0, err
+	}
+	return size * unit, nil
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+	EncodingBrotli  = "br"
+
+	DefaultCompressMinLength = 256
+)
+
+var (
+	// DefaultCompressTypes are used when gzip directive doesn't specify types
+	DefaultCompressTypes = []string{
+		"text/",
+		"application/json",
+		"application/javascript",
+		"application/x-javascript",
+		"application/xml",
+		"application/xhtml+xml",
+		"application/rss+xml",
+		"image/svg+xml",
+	}
+	// DefaultCompressEncodings lists the supported encodings in order of preference
+	DefaultCompressEncodings = []string{EncodingBrotli, EncodingGzip, EncodingDeflate}
+
+	strVary           = "Vary"
+	strAcceptEncoding = "Accept-Encoding"
+	strContentEncode  = "Content-Encoding"
+)
+
+type compressor struct {
+	level     int
+	minLength int
+	types     []string
+	encodings []string
+	brPool    sync.Pool
+	// streamEncoder encodes streamed bodies by the Accept-Encoding of the request
+	streamEncoder fasthttp.RequestHandler
+}
+
+func newCompressor(cfg GzipConfig) *compressor {
+	c := &compressor{
+		level:     cfg.Level,
+		minLength: cfg.MinLength,
+		types:     cfg.Types,
+		encodings: cfg.Encodings,
+	}
+	if c.minLength <= 0 {
+		c.minLength = DefaultCompressMinLength
+	}
+	if len(c.types) == 0 {
+		c.types = DefaultCompressTypes
+	}
+	if len(c.encodings) == 0 {
+		c.encodings = DefaultCompressEncodings
+	}
+	c.streamEncoder = fasthttp.CompressHandlerBrotliLevel(func(*fasthttp.RequestCtx) {}, c.brotliLevel(), c.gzipLevel())
+	return c
+}
+
+func (c *compressor) Handle(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		next(ctx)
+		c.compress(ctx)
+	}
+}
+
+func (c *compressor) compress(ctx *fasthttp.RequestCtx) {
+	resp := &ctx.Response
+	if !c.compressibleStatus(resp.StatusCode()) {
+		return
+	}
+	if len(resp.Header.Peek(strContentEncode)) > 0 {
+		// already encoded by upstream or static handler
+		return
+	}
+	if !c.matchType(resp.Header.ContentType()) {
+		return
+	}
+	// the representation varies on Accept-Encoding as long as it's a candidate
+	addVary(&resp.Header)
+	if resp.IsBodyStream() {
+		// the length of streamed bodies such as files served by static is known beforehand, -1 if it's not
+		if n := resp.Header.ContentLength(); n >= 0 && n < c.minLength {
+			return
+		}
+		if encoding := c.negotiate(ctx.Request.Header.Peek(strAcceptEncoding)); encoding != "" {
+			c.compressStream(ctx, encoding)
+		}
+		return
+	}
+	body := resp.Body()
+	if len(body) < c.minLength {
+		return
+	}
+	encoding := c.negotiate(ctx.Request.Header.Peek(strAcceptEncoding))
+	if encoding == "" {
+		return
+	}
+	var compressed []byte
+	switch encoding {
+	case EncodingGzip:
+		compressed = fasthttp.AppendGzipBytesLevel(nil, body, c.gzipLevel())
+	case EncodingDeflate:
+		compressed = fasthttp.AppendDeflateBytesLevel(nil, body, c.gzipLevel())
+	case EncodingBrotli:
+		compressed = c.brotli(body)
+	}
+	if len(compressed) == 0 || len(compressed) >= len(body) {
+		return
+	}
+	resp.SwapBody(compressed)
+	resp.Header.Set(strContentEncode, encoding)
+}
+
+// compressStream wraps the streamed body in the pooled encoder of fasthttp, which is sent with chunked encoding.
+// The stream can't be taken out of the response without closing it, so fasthttp is told the encoding by Accept-Encoding
+func (c *compressor) compressStream(ctx *fasthttp.RequestCtx, encoding string) {
+	accept := append([]byte(nil), ctx.Request.Header.Peek(strAcceptEncoding)...)
+	ctx.Request.Header.Set(strAcceptEncoding, encoding)
+	c.streamEncoder(ctx)
+	ctx.Request.Header.SetBytesV(strAcceptEncoding, accept)
+}
+
+func (c *compressor) compressibleStatus(code int) bool {
+	return code >= 200 && code != fasthttp.StatusNoContent && code != fasthttp.StatusNotModified && code != fasthttp.StatusPartialContent
+}
+
+func (c *compressor) matchType(contentType []byte) bool {
+	if idx := bytes.IndexByte(contentType, ';'); idx != -1 {
+		contentType = contentType[:idx]
+	}
+	ct := strings.ToLower(strings.TrimSpace(string(contentType)))
+	for _, t := range c.types {
+		if strings.HasSuffix(t, "/") {
+			if strings.HasPrefix(ct, t) {
+				return true
+			}
+		} else if ct == t {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipLevel maps the configured level to the range compress/flate accepts
+func (c *compressor) gzipLevel() int {
+	if c.level < fasthttp.CompressBestSpeed || c.level > fasthttp.CompressBestCompression {
+		return fasthttp.CompressDefaultCompression
+	}
+	return c.level
+}
+
+// brotliLevel maps the configured level to the range brotli accepts
+func (c *compressor) brotliLevel() int {
+	if c.level < brotli.BestSpeed || c.level > brotli.BestCompression {
+		return brotli.DefaultCompression
+	}
+	return c.level
+}
+
+func (c *compressor) brotli(body []byte) []byte {
+	var buf bytes.Buffer
+	w, ok := c.brPool.Get().(*brotli.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		w = brotli.NewWriterLevel(&buf, c.brotliLevel())
+	}
+	defer c.brPool.Put(w)
+	if _, err := w.Write(body); err != nil {
+		return nil
+	}
+	if err := w.Close(); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// negotiate picks the most preferred encoding the client accepts with a non-zero qvalue
+func (c *compressor) negotiate(acceptEncoding []byte) string {
+	if len(acceptEncoding) == 0 {
+		return ""
+	}
+	accepted := parseAcceptEncoding(string(acceptEncoding))
+	best, bestQ := "", 0.0
+	for _, enc := range c.encodings {
+		q, ok := accepted[enc]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+func parseAcceptEncoding(s string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		q := 1.0
+		if idx := strings.IndexByte(part, ';'); idx != -1 {
+			param := strings.TrimSpace(part[idx+1:])
+			part = strings.TrimSpace(part[:idx])
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[strings.ToLower(part)] = q
+	}
+	return accepted
+}
+
+func addVary(h *fasthttp.ResponseHeader) {
+	vary := h.Peek(strVary)
+	if len(vary) == 0 {
+		h.Set(strVary, strAcceptEncoding)
+		return
+	}
+	for _, v := range bytes.Split(vary, []byte{','}) {
+		if strings.EqualFold(string(bytes.TrimSpace(v)), strAcceptEncoding) {
+			return
+		}
+	}
+	h.Set(strVary, string(vary)+", "+strAcceptEncoding)
+}
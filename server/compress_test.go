@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestCompressor_negotiate(t *testing.T) {
+	c := newCompressor(GzipConfig{})
+	var testCases = []struct {
+		accept string
+		expect string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"br;q=0, deflate", "deflate"},
+		{"*", "br"},
+		{"identity", ""},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.expect, c.negotiate([]byte(tc.accept)), "accept %q", tc.accept)
+	}
+}
+
+func TestCompressor_Handle(t *testing.T) {
+	body := strings.Repeat("durian is a fast web server. ", 100)
+	var testCases = []struct {
+		name        string
+		accept      string
+		contentType string
+		encoded     string
+		body        string
+		stream      bool
+		expect      string
+	}{
+		{name: "gzip", accept: "gzip", contentType: "text/html; charset=utf-8", body: body, expect: "gzip"},
+		{name: "brotli", accept: "br", contentType: "application/json", body: body, expect: "br"},
+		{name: "not accepted", accept: "", contentType: "text/plain", body: body, expect: ""},
+		{name: "too short", accept: "gzip", contentType: "text/plain", body: "short", expect: ""},
+		{name: "type not allowed", accept: "gzip", contentType: "image/png", body: body, expect: ""},
+		{name: "already encoded", accept: "gzip", contentType: "text/plain", encoded: "gzip", body: body, expect: "gzip"},
+		{name: "stream gzip", accept: "gzip;q=1.0, br;q=0.5", contentType: "text/plain", body: body, stream: true, expect: "gzip"},
+		{name: "stream brotli", accept: "br", contentType: "text/plain", body: body, stream: true, expect: "br"},
+		{name: "stream too short", accept: "gzip", contentType: "text/plain", body: "short", stream: true, expect: ""},
+	}
+	c := newCompressor(GzipConfig{Open: true, Level: 6})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			should := require.New(tt)
+			handler := c.Handle(func(ctx *fasthttp.RequestCtx) {
+				ctx.SetContentType(tc.contentType)
+				if tc.encoded != "" {
+					ctx.Response.Header.Set("Content-Encoding", tc.encoded)
+				}
+				if tc.stream {
+					ctx.SetBodyStream(strings.NewReader(tc.body), len(tc.body))
+				} else {
+					ctx.SetBodyString(tc.body)
+				}
+			})
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.Header.Set("Accept-Encoding", tc.accept)
+			handler(ctx)
+			should.Equal(tc.accept, string(ctx.Request.Header.Peek("Accept-Encoding")))
+			should.Equal(tc.expect, string(ctx.Response.Header.Peek("Content-Encoding")))
+			if tc.stream && tc.expect != "" {
+				// the length of encoded stream is unknown
+				should.Equal(-1, ctx.Response.Header.ContentLength())
+			}
+			if tc.expect == "gzip" && tc.encoded == "" {
+				plain, err := ctx.Response.BodyGunzip()
+				should.NoError(err)
+				should.Equal(tc.body, string(plain))
+			}
+			if tc.expect == "br" {
+				plain, err := ctx.Response.BodyUnbrotli()
+				should.NoError(err)
+				should.Equal(tc.body, string(plain))
+			}
+			if tc.expect == "" {
+				should.True(bytes.Equal([]byte(tc.body), ctx.Response.Body()))
+			}
+			if tc.contentType != "image/png" && tc.encoded == "" {
+				should.Equal("Accept-Encoding", string(ctx.Response.Header.Peek("Vary")))
+			}
+		})
+	}
+}
+
+func TestCompressor_StaticFile(t *testing.T) {
+	should := require.New(t)
+	dir, err := ioutil.TempDir("", "durian-compress")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+	body := strings.Repeat("durian is a fast web server. ", 100)
+	path := filepath.Join(dir, "index.html")
+	should.NoError(ioutil.WriteFile(path, []byte(body), 0644))
+
+	// fasthttp.FS always sends files as streams
+	handler := newCompressor(GzipConfig{Open: true}).Handle(func(ctx *fasthttp.RequestCtx) {
+		fasthttp.ServeFile(ctx, path)
+	})
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/index.html")
+	ctx.Request.Header.Set("Accept-Encoding", "gzip")
+	handler(ctx)
+	should.Equal("gzip", string(ctx.Response.Header.Peek("Content-Encoding")))
+	plain, err := ctx.Response.BodyGunzip()
+	should.NoError(err)
+	should.Equal(body, string(plain))
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/caibirdme/durian/replace"
+	"github.com/valyala/fasthttp"
+)
+
+// ErrorPageConfig describes the page sent instead of the original error response
+type ErrorPageConfig struct {
+	// StatusCode overrides the original status code if not 0
+	StatusCode  int
+	File        string
+	ContentType string
+	Body        string
+}
+
+const (
+	// check file's modification at most once per fileCheckInterval
+	fileCheckInterval = time.Second
+)
+
+// errorPage serves either a cached file or a templated body
+type errorPage struct {
+	statusCode  int
+	contentType string
+	body        string
+	templates   *replace.VariablePlaceholder
+	file        *cachedFile
+}
+
+func newErrorPage(cfg ErrorPageConfig) *errorPage {
+	page := &errorPage{
+		statusCode:  cfg.StatusCode,
+		contentType: cfg.ContentType,
+		body:        cfg.Body,
+	}
+	if cfg.File != "" {
+		page.file = &cachedFile{path: cfg.File}
+	} else {
+		page.templates = replace.NewVariablePlaceholder()
+		page.templates.SetTmpl(cfg.Body)
+	}
+	return page
+}
+
+func (p *errorPage) Serve(ctx *fasthttp.RequestCtx) {
+	if p.statusCode != 0 {
+		ctx.SetStatusCode(p.statusCode)
+	}
+	if p.contentType != "" {
+		ctx.SetContentType(p.contentType)
+	}
+	// drop whatever the original handler has written, such as Content-Encoding
+	ctx.Response.Header.Del(strContentEncode)
+	if p.file != nil {
+		content, err := p.file.Content()
+		if err != nil {
+			ctx.SetBodyString(fasthttp.StatusMessage(ctx.Response.StatusCode()))
+			return
+		}
+		ctx.SetBody(content)
+		return
+	}
+	body, err := p.templates.ExecuteFuncString(p.body, func(w io.Writer, tag string) (int, error) {
+		n, err := replace.ReplaceVariable(ctx, w, tag)
+		if err == replace.ErrNotBuiltin {
+			// keep unknown tags as they are
+			return w.Write([]byte("{" + tag + "}"))
+		}
+		return n, err
+	})
+	if err != nil {
+		body = p.body
+	}
+	ctx.SetBodyString(body)
+}
+
+// cachedFile keeps the file content in memory and reloads it once it's modified
+type cachedFile struct {
+	path string
+
+	mu        sync.RWMutex
+	content   []byte
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func (f *cachedFile) Content() ([]byte, error) {
+	now := time.Now()
+	f.mu.RLock()
+	content := f.content
+	fresh := content != nil && now.Sub(f.checkedAt) < fileCheckInterval
+	f.mu.RUnlock()
+	if fresh {
+		return content, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	f.checkedAt = now
+	if f.content != nil && info.ModTime().Equal(f.modTime) {
+		return f.content, nil
+	}
+	content, err = ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	f.content, f.modTime = content, info.ModTime()
+	return f.content, nil
+}
+
+// newNotFoundHandler is the final handler of the middleware chain
+func newNotFoundHandler(cfg NotFoundConfig) fasthttp.RequestHandler {
+	if cfg.StatusCode == 0 && cfg.File == "" && cfg.Body == "" {
+		return notFoundHandler
+	}
+	page := newErrorPage(ErrorPageConfig{
+		StatusCode:  cfg.StatusCode,
+		File:        cfg.File,
+		ContentType: cfg.ContentType,
+		Body:        cfg.Body,
+	})
+	if page.statusCode == 0 {
+		page.statusCode = fasthttp.StatusNotFound
+	}
+	return page.Serve
+}
+
+// newErrorPageMiddleware replaces the response with the configured page
+// if the status code produced by the inner handlers has one
+func newErrorPageMiddleware(pages map[int]ErrorPageConfig) Middleware {
+	handlers := make(map[int]*errorPage, len(pages))
+	for code, cfg := range pages {
+		handlers[code] = newErrorPage(cfg)
+	}
+	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			next(ctx)
+			if page, ok := handlers[ctx.Response.StatusCode()]; ok {
+				page.Serve(ctx)
+			}
+		}
+	}
+}
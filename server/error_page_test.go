@@ -0,0 +1,68 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestNewNotFoundHandler(t *testing.T) {
+	should := require.New(t)
+	handler := newNotFoundHandler(NotFoundConfig{
+		StatusCode:  fasthttp.StatusNotFound,
+		ContentType: "text/plain",
+		Body:        "{path} is missing, {unknown}",
+	})
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/foo/bar")
+	handler(ctx)
+	should.Equal(fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	should.Equal("text/plain", string(ctx.Response.Header.ContentType()))
+	should.Equal("/foo/bar is missing, {unknown}", string(ctx.Response.Body()))
+}
+
+func TestErrorPageMiddleware_File(t *testing.T) {
+	should := require.New(t)
+	f, err := ioutil.TempFile("", "durian_50x")
+	should.NoError(err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("oops")
+	should.NoError(err)
+	should.NoError(f.Close())
+
+	m := newErrorPageMiddleware(map[int]ErrorPageConfig{
+		fasthttp.StatusBadGateway: {File: f.Name(), ContentType: "text/html"},
+	})
+	handler := m(func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/bad" {
+			ctx.Error("backend down", fasthttp.StatusBadGateway)
+			return
+		}
+		ctx.SetBodyString("fine")
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/bad")
+	handler(ctx)
+	should.Equal(fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	should.Equal("oops", string(ctx.Response.Body()))
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/good")
+	handler(ctx)
+	should.Equal("fine", string(ctx.Response.Body()))
+
+	// the file is re-read once it's modified
+	should.NoError(ioutil.WriteFile(f.Name(), []byte("changed"), 0644))
+	future := time.Now().Add(time.Hour)
+	should.NoError(os.Chtimes(f.Name(), future, future))
+	time.Sleep(fileCheckInterval)
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/bad")
+	handler(ctx)
+	should.Equal("changed", string(ctx.Response.Body()))
+}
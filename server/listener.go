@@ -1,7 +1,10 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"net"
+	"os"
 	"time"
 )
 
@@ -25,3 +28,30 @@ func (ln tcpKeepaliveListener) Accept() (net.Conn, error) {
 	}
 	return tc, nil
 }
+
+// tlsListener wraps a plain listener and performs the TLS handshake lazily on the
+// accepted connections. It keeps File() available so caddy can still hand the
+// underlying socket over during a graceful restart.
+type tlsListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+func newTLSListener(ln net.Listener, config *tls.Config) *tlsListener {
+	return &tlsListener{Listener: ln, config: config}
+}
+
+func (ln *tlsListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return tls.Server(c, ln.config), nil
+}
+
+func (ln *tlsListener) File() (*os.File, error) {
+	if f, ok := ln.Listener.(interface{ File() (*os.File, error) }); ok {
+		return f.File()
+	}
+	return nil, errors.New("underlying listener doesn't support File()")
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Modifiers of location, the same as nginx
+const (
+	LocationExact         = "="
+	LocationPrefix        = ""
+	LocationPriorPrefix   = "^~"
+	LocationRegex         = "~"
+	LocationRegexCaseless = "~*"
+)
+
+// Location groups the middlewares of directives inside a location block
+type Location struct {
+	Modifier    string
+	Pattern     string
+	re          *regexp.Regexp
+	middlewares []Middleware
+	handler     fasthttp.RequestHandler
+}
+
+func NewLocation(modifier, pattern string) (*Location, error) {
+	lo := &Location{Modifier: modifier, Pattern: pattern}
+	switch modifier {
+	case LocationExact, LocationPrefix, LocationPriorPrefix:
+	case LocationRegex, LocationRegexCaseless:
+		expr := pattern
+		if modifier == LocationRegexCaseless {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		lo.re = re
+	default:
+		return nil, fmt.Errorf("unknown location modifier %s", modifier)
+	}
+	return lo, nil
+}
+
+func (lo *Location) String() string {
+	if lo.Modifier == LocationPrefix {
+		return lo.Pattern
+	}
+	return lo.Modifier + " " + lo.Pattern
+}
+
+// AddLocation runs setup, which registers the middlewares of the directives inside the location block,
+// and keeps those middlewares in lo rather than the server
+func (cfg *ServerConfig) AddLocation(lo *Location, setup func() error) error {
+	for _, exist := range cfg.locations {
+		if exist.Pattern == lo.Pattern && (exist.Modifier == lo.Modifier || isPrefix(exist.Modifier) && isPrefix(lo.Modifier)) {
+			return fmt.Errorf("duplicate location %s", lo)
+		}
+	}
+	saved := cfg.middlewares
+	cfg.middlewares = nil
+	err := setup()
+	lo.middlewares = cfg.middlewares
+	cfg.middlewares = saved
+	if err != nil {
+		return err
+	}
+	cfg.locations = append(cfg.locations, lo)
+	return nil
+}
+
+func isPrefix(modifier string) bool {
+	return modifier == LocationPrefix || modifier == LocationPriorPrefix
+}
+
+// locationTree finds the location of a path like nginx does:
+// exact match wins at once, then the longest prefix is remembered. If it's ^~, it's used,
+// otherwise regular expressions are checked in the order they're defined, the first matched one wins.
+// The longest prefix is used if no regular expression matches
+type locationTree struct {
+	exact    map[string]*Location
+	prefixes radixTree
+	regexps  *regexpSet
+}
+
+func newLocationTree(locations []*Location) *locationTree {
+	t := &locationTree{exact: make(map[string]*Location)}
+	var regexps []*Location
+	for _, lo := range locations {
+		switch lo.Modifier {
+		case LocationExact:
+			t.exact[lo.Pattern] = lo
+		case LocationPrefix, LocationPriorPrefix:
+			t.prefixes.insert(lo.Pattern, lo)
+		default:
+			regexps = append(regexps, lo)
+		}
+	}
+	t.regexps = newRegexpSet(regexps)
+	return t
+}
+
+func (t *locationTree) find(path []byte) *Location {
+	if lo, ok := t.exact[string(path)]; ok {
+		return lo
+	}
+	prefix := t.prefixes.longestPrefix(path)
+	if prefix != nil && prefix.Modifier == LocationPriorPrefix {
+		return prefix
+	}
+	if lo := t.regexps.find(path); lo != nil {
+		return lo
+	}
+	return prefix
+}
+
+// newLocationMiddleware dispatches a request to the matched location, whose directives handle it
+// and fall back to final. Requests matching no location are passed to next
+func newLocationMiddleware(locations []*Location, final fasthttp.RequestHandler) Middleware {
+	t := newLocationTree(locations)
+	for _, lo := range locations {
+		lo.handler = compileMiddleware(lo.middlewares, final)
+	}
+	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			if lo := t.find(ctx.Path()); lo != nil {
+				lo.handler(ctx)
+				return
+			}
+			next(ctx)
+		}
+	}
+}
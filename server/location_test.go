@@ -0,0 +1,96 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestLocationTree_Find(t *testing.T) {
+	should := require.New(t)
+	var locations []*Location
+	for _, args := range [][2]string{
+		{LocationExact, "/"},
+		{LocationPrefix, "/"},
+		{LocationPrefix, "/static"},
+		{LocationPriorPrefix, "/static/img"},
+		{LocationRegexCaseless, `\.(png|jpg)$`},
+		{LocationRegex, `\.png$`},
+		{LocationPrefix, "/static/img/raw"},
+	} {
+		lo, err := NewLocation(args[0], args[1])
+		should.NoError(err)
+		locations = append(locations, lo)
+	}
+	tree := newLocationTree(locations)
+	testCases := []struct {
+		path   string
+		expect string
+	}{
+		{path: "/", expect: "= /"},
+		{path: "/index.html", expect: "/"},
+		{path: "/a.PNG", expect: `~* \.(png|jpg)$`},
+		{path: "/static/a.css", expect: "/static"},
+		{path: "/static/a.png", expect: `~* \.(png|jpg)$`},
+		{path: "/static/img/a.png", expect: "^~ /static/img"},
+		// the longest prefix isn't ^~, so regexps are checked
+		{path: "/static/img/raw/a.png", expect: `~* \.(png|jpg)$`},
+		{path: "/static/img/raw/a.txt", expect: "/static/img/raw"},
+	}
+	for _, tc := range testCases {
+		lo := tree.find([]byte(tc.path))
+		should.NotNil(lo, tc.path)
+		should.Equal(tc.expect, lo.String(), tc.path)
+	}
+
+	lo, err := NewLocation(LocationRegex, `\.php$`)
+	should.NoError(err)
+	should.Nil(newLocationTree([]*Location{lo}).find([]byte("/index.html")))
+
+	_, err = NewLocation("!", "/")
+	should.Error(err)
+	_, err = NewLocation(LocationRegex, "(")
+	should.Error(err)
+}
+
+func TestAddLocation(t *testing.T) {
+	should := require.New(t)
+	cfg := &ServerConfig{}
+	respond := func(body string) Middleware {
+		return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+			return func(ctx *fasthttp.RequestCtx) {
+				ctx.SetBodyString(body)
+			}
+		}
+	}
+	cfg.AddMiddleware(respond("outside"))
+	api, err := NewLocation(LocationPrefix, "/api")
+	should.NoError(err)
+	should.NoError(cfg.AddLocation(api, func() error {
+		cfg.AddMiddleware(respond("api"))
+		return nil
+	}))
+	empty, err := NewLocation(LocationExact, "/empty")
+	should.NoError(err)
+	should.NoError(cfg.AddLocation(empty, func() error { return nil }))
+	// middlewares inside location blocks are kept by the locations
+	should.Len(cfg.middlewares, 1)
+
+	dup, err := NewLocation(LocationPriorPrefix, "/api")
+	should.NoError(err)
+	should.Error(cfg.AddLocation(dup, func() error { return nil }))
+
+	handler := cfg.makeHandler()
+	for path, expect := range map[string]string{"/api/v1": "api", "/other": "outside"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(path)
+		handler(ctx)
+		should.Equal(expect, string(ctx.Response.Body()), path)
+	}
+	// the requests not handled by the location don't fall through to the directives outside
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/empty")
+	handler(ctx)
+	should.Equal(fasthttp.StatusNotFound, ctx.Response.StatusCode())
+}
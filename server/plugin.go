@@ -1,10 +1,9 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/mholt/caddy"
@@ -40,10 +39,12 @@ func newContext(inst *caddy.Instance) caddy.Context {
 	}
 }
 
+// GetConfig returns the config of the site which the controller is setting up,
+// directives are executed for each key of a server block
 func GetConfig(c *caddy.Controller) *ServerConfig {
 	f := c.Context().(*fastContext)
 	for i := 0; i < len(f.cfg); i++ {
-		if f.cfg[i].Addr == c.Key {
+		if f.cfg[i].block == c.ServerBlockIndex && f.cfg[i].Addr == c.Key {
 			return &f.cfg[i]
 		}
 	}
@@ -51,14 +52,24 @@ func GetConfig(c *caddy.Controller) *ServerConfig {
 }
 
 type GzipConfig struct {
-	Open  bool
-	Level int
+	Open      bool
+	Level     int
+	MinLength int
+	// Types is a list of content types to compress, entries end with "/" match as prefix
+	Types     []string
+	Encodings []string
 }
 
-// ServerConfig stores the configuration for fasthttp.Server
+// ServerConfig stores the configuration of a site, which is a key of a server block.
+// Sites listening on the same address share a fasthttp.Server
 type ServerConfig struct {
-	Root                          string
-	Addr                          string
+	Root string
+	// Addr is the key of server block
+	Addr string
+	// Host is the server name matched against the Host header, empty for the default site
+	Host string
+	// ListenAddr is the address to listen
+	ListenAddr                    string
 	Name                          string
 	Concurrency                   int
 	DisableKeepalive              bool
@@ -75,11 +86,19 @@ type ServerConfig struct {
 	DisableHeaderNamesNormalizing bool
 	NoDefaultServerHeader         bool
 	NoDefaultContentType          bool
+	TLS                           *tls.Config
 	Gzip                          GzipConfig
 	NotFound                      NotFoundConfig
+	ErrorPages                    map[int]ErrorPageConfig
 	middlewares                   []Middleware
 	namedMiddleware               map[string]Middleware
+	locations                     []*Location
+	matchers                      map[string]LocationMatcher
 	RequestIDName                 string
+	// block is the index of server block
+	block int
+	// streamLocations get request bodies as streams, see StreamRequestBody
+	streamLocations []LocationMatcher
 }
 
 type NotFoundConfig struct {
@@ -93,6 +112,14 @@ func (cfg *ServerConfig) AddMiddleware(m Middleware) {
 	cfg.middlewares = append(cfg.middlewares, m)
 }
 
+// AddErrorPage registers page for the given status code, the later one wins
+func (cfg *ServerConfig) AddErrorPage(code int, page ErrorPageConfig) {
+	if cfg.ErrorPages == nil {
+		cfg.ErrorPages = make(map[int]ErrorPageConfig)
+	}
+	cfg.ErrorPages[code] = page
+}
+
 func (cfg *ServerConfig) AddNamedMiddleware(name string, m Middleware) {
 	if cfg.namedMiddleware == nil {
 		cfg.namedMiddleware = make(map[string]Middleware)
@@ -100,120 +127,170 @@ func (cfg *ServerConfig) AddNamedMiddleware(name string, m Middleware) {
 	cfg.namedMiddleware[name] = m
 }
 
+// AddNamedMatcher registers the matcher referred as @name by directives
+func (cfg *ServerConfig) AddNamedMatcher(name string, m LocationMatcher) error {
+	if cfg.matchers == nil {
+		cfg.matchers = make(map[string]LocationMatcher)
+	}
+	if _, ok := cfg.matchers[name]; ok {
+		return fmt.Errorf("duplicate matcher %s", name)
+	}
+	cfg.matchers[name] = m
+	return nil
+}
+
+func (cfg *ServerConfig) NamedMatcher(name string) (LocationMatcher, bool) {
+	m, ok := cfg.matchers[name]
+	return m, ok
+}
+
 const (
-	LogMiddlewareName    = "log"
-	UUIDMiddlewareName   = "uuid"
-	RouterMiddlewareName = "router"
+	LogMiddlewareName     = "log"
+	UUIDMiddlewareName    = "uuid"
+	RouterMiddlewareName  = "router"
+	MetricsMiddlewareName = "metrics"
 )
 
-func (cfg *ServerConfig) makeServer() *fasthttp.Server {
+// makeHandler composes the middlewares of the site
+func (cfg *ServerConfig) makeHandler() fasthttp.RequestHandler {
 	var handler fasthttp.RequestHandler
+	final := newNotFoundHandler(cfg.NotFound)
 	// mount user defined middleware
 	if cfg.namedMiddleware != nil {
 		if selfRouter, ok := cfg.namedMiddleware[RouterMiddlewareName]; ok {
-			handler = selfRouter(notFoundHandler)
+			handler = selfRouter(final)
 		}
 	}
 	// if there's not user defined middleware, just use not found as the final handler
 	if handler == nil {
-		handler = compileMiddleware(cfg.middlewares, notFoundHandler)
+		handler = compileMiddleware(cfg.middlewares, final)
 	} else {
 		handler = compileMiddleware(cfg.middlewares, handler)
 	}
+	// location blocks are checked before the directives outside them
+	if len(cfg.locations) > 0 {
+		handler = newLocationMiddleware(cfg.locations, final)(handler)
+	}
+	handler = withInternalRedirect(handler)
+	handler = cfg.withRequestBody(handler)
+	if len(cfg.ErrorPages) > 0 {
+		handler = newErrorPageMiddleware(cfg.ErrorPages)(handler)
+	}
+	// compress the final response produced by all middlewares
+	if cfg.Gzip.Open {
+		handler = newCompressor(cfg.Gzip).Handle(handler)
+	}
 	// mount uuid at the very beginning
 	if cfg.namedMiddleware != nil {
 		if m, ok := cfg.namedMiddleware[UUIDMiddlewareName]; ok {
 			handler = m(handler)
 		}
 	}
+	// metrics observe everything except the log
+	if cfg.namedMiddleware != nil {
+		if m, ok := cfg.namedMiddleware[MetricsMiddlewareName]; ok {
+			handler = m(handler)
+		}
+	}
 	// mount the log as the outermost middleware
 	if cfg.namedMiddleware != nil {
 		if m, ok := cfg.namedMiddleware[LogMiddlewareName]; ok {
 			handler = m(handler)
 		}
 	}
-	srv := &fasthttp.Server{
-		Handler: handler,
-	}
-	if d := cfg.MaxKeepaliveDuration; d != 0 {
-		srv.MaxKeepaliveDuration = d
-	}
-	if d := cfg.ReadTimeout; d != 0 {
-		srv.ReadTimeout = d
-	}
-	if d := cfg.WriteTimeout; d != 0 {
-		srv.WriteTimeout = d
-	}
-	if cfg.Concurrency != 0 {
-		srv.Concurrency = cfg.Concurrency
-	}
-	if cfg.DisableKeepalive {
-		srv.DisableKeepalive = true
-	}
-	return srv
+	return handler
 }
 
-func (c *fastContext) InspectServerBlocks(path string, sblocks []caddyfile.ServerBlock) ([]caddyfile.ServerBlock, error) {
-	for _, sblock := range sblocks {
-		cfg, err := c.parseConfig(sblock)
-		if nil != err {
-			return sblocks, err
-		}
-		c.cfg = append(c.cfg, cfg)
+// makeServer returns the fasthttp.Server serving handler with the options of cfg, zero values mean the defaults of fasthttp
+func (cfg *ServerConfig) makeServer(handler fasthttp.RequestHandler) *fasthttp.Server {
+	return &fasthttp.Server{
+		Handler:                       handler,
+		Name:                          cfg.Name,
+		Concurrency:                   cfg.Concurrency,
+		DisableKeepalive:              cfg.DisableKeepalive,
+		ReadBufferSize:                cfg.ReadBufferSize,
+		WriteBufferSize:               cfg.WriteBufferSize,
+		ReadTimeout:                   cfg.ReadTimeout,
+		WriteTimeout:                  cfg.WriteTimeout,
+		MaxConnsPerIP:                 cfg.MaxConnsPerIP,
+		MaxRequestsPerConn:            cfg.MaxRequestsPerConn,
+		MaxKeepaliveDuration:          cfg.MaxKeepaliveDuration,
+		TCPKeepalive:                  cfg.TCPKeepalive,
+		TCPKeepalivePeriod:            cfg.TCPKeepalivePeriod,
+		MaxRequestBodySize:            cfg.MaxRequestBodySize,
+		DisableHeaderNamesNormalizing: cfg.DisableHeaderNamesNormalizing,
+		NoDefaultServerHeader:         cfg.NoDefaultServerHeader,
+		NoDefaultContentType:          cfg.NoDefaultContentType,
 	}
-	return sblocks, nil
 }
 
-func (c *fastContext) parseConfig(sblock caddyfile.ServerBlock) (ServerConfig, error) {
-	cfg := ServerConfig{Addr: sblock.Keys[0]}
-	for key, vals := range sblock.Tokens {
-		switch strings.ToLower(key) {
-		case "concurrency":
-			if len(vals) > 0 {
-				val := vals[0].Text
-				num, err := strconv.Atoi(val)
-				if nil != err {
-					return cfg, fmt.Errorf("%+v, Err: %s", vals, err)
-				}
-				cfg.Concurrency = num
+func (c *fastContext) InspectServerBlocks(path string, sblocks []caddyfile.ServerBlock) ([]caddyfile.ServerBlock, error) {
+	sites := make(map[string]string)
+	for i, sblock := range sblocks {
+		for _, key := range sblock.Keys {
+			cfg, err := c.parseConfig(sblock)
+			if nil != err {
+				return sblocks, err
 			}
-		case "keepalive":
-			if len(vals) > 0 {
-				val := vals[0].Text
-				ok, err := strconv.ParseBool(val)
-				if nil != err {
-					return cfg, fmt.Errorf("%+v, Err: %s", vals, err)
-				}
-				// ok equals true means EnableKeepalive
-				cfg.DisableKeepalive = !ok
+			cfg.Addr, cfg.block = key, i
+			cfg.Host, cfg.ListenAddr, err = parseSiteAddr(key)
+			if err != nil {
+				return sblocks, err
 			}
-		case "root":
-			if len(vals) > 0 {
-				cfg.Root = vals[0].Text
+			site := cfg.Host + " " + cfg.ListenAddr
+			if exist, ok := sites[site]; ok {
+				return sblocks, fmt.Errorf("duplicate site %s and %s", exist, key)
 			}
+			sites[site] = key
+			c.cfg = append(c.cfg, cfg)
 		}
 	}
-	if cfg.Root == "" {
-		curDir, err := os.Getwd()
-		if err != nil {
-			return cfg, err
-		}
-		cfg.Root = curDir
+	return sblocks, nil
+}
+
+// parseConfig returns the config of a site in sblock, options of the server are set by the server directive
+func (c *fastContext) parseConfig(sblock caddyfile.ServerBlock) (ServerConfig, error) {
+	cfg := ServerConfig{}
+	curDir, err := os.Getwd()
+	if err != nil {
+		return cfg, err
 	}
+	cfg.Root = curDir
 	return cfg, nil
 }
 
+// MakeServers makes a server for each listening address, which dispatches requests to its sites by Host
 func (c *fastContext) MakeServers() ([]caddy.Server, error) {
+	var addrs []string
+	groups := make(map[string][]*ServerConfig)
+	for i := range c.cfg {
+		cfg := &c.cfg[i]
+		if _, ok := groups[cfg.ListenAddr]; !ok {
+			addrs = append(addrs, cfg.ListenAddr)
+		}
+		groups[cfg.ListenAddr] = append(groups[cfg.ListenAddr], cfg)
+	}
 	var servers []caddy.Server
-	for _, cfg := range c.cfg {
-		servers = append(servers, NewFastServer(cfg))
+	for _, addr := range addrs {
+		srv, err := NewFastServer(addr, groups[addr])
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, srv)
 	}
 	return servers, nil
 }
 
 var directives = []string{
+	// the server directive sets the root, which is used by static
+	DirectiveServer,
+	DirectiveMatcher,
+	DirectiveTLS,
 	DirectiveLog,
+	DirectiveRequestID,
+	DirectiveMetrics,
 	DirectiveUpstream,
+	DirectiveLocation,
 	DirectiveFastCgi,
 	DirectiveGzip,
 	DirectiveProxy,
@@ -223,22 +300,33 @@ var directives = []string{
 	DirectiveRewrite,
 	DirectiveStatus,
 	DirectiveResponse,
+	DirectiveUpstreamStatus,
 	DirectiveNotFound,
+	DirectiveErrorPage,
 	DirectiveRouter,
 }
 
 const (
-	DirectiveProxy    = "proxy"
-	DirectiveHeader   = "header"
-	DirectiveTimeout  = "timeout"
-	DirectiveStatic   = "static"
-	DirectiveRewrite  = "rewrite"
-	DirectiveStatus   = "status"
-	DirectiveResponse = "response"
-	DirectiveGzip     = "gzip"
-	DirectiveNotFound = "not_found"
-	DirectiveLog      = "log"
-	DirectiveRouter   = "router"
-	DirectiveFastCgi  = "fastcgi"
-	DirectiveUpstream = "upstream"
+	DirectiveProxy     = "proxy"
+	DirectiveHeader    = "header"
+	DirectiveTimeout   = "timeout"
+	DirectiveStatic    = "static"
+	DirectiveRewrite   = "rewrite"
+	DirectiveStatus    = "status"
+	DirectiveResponse  = "response"
+	DirectiveGzip      = "gzip"
+	DirectiveNotFound  = "not_found"
+	DirectiveLog       = "log"
+	DirectiveRouter    = "router"
+	DirectiveFastCgi   = "fastcgi"
+	DirectiveUpstream  = "upstream"
+	DirectiveTLS       = "tls"
+	DirectiveErrorPage = "error_page"
+	DirectiveRequestID = "request_id"
+	DirectiveMetrics   = "metrics"
+	DirectiveLocation  = "location"
+	DirectiveMatcher   = "matcher"
+	DirectiveServer    = "server"
+	// DirectiveUpstreamStatus exposes the state of upstreams
+	DirectiveUpstreamStatus = "upstream_status"
 )
@@ -1,29 +1,190 @@
 package server
 
 import (
+	"crypto/tls"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mholt/caddy/caddyfile"
 	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
 )
 
+func inspect(t *testing.T, input string) (*fastContext, error) {
+	sblocks, err := caddyfile.Parse("Caddyfile", strings.NewReader(input), nil)
+	require.NoError(t, err)
+	c := &fastContext{}
+	_, err = c.InspectServerBlocks("Caddyfile", sblocks)
+	return c, err
+}
+
 func TestConvertCaddyfile(t *testing.T) {
-	var cfg = `
-	:8080,:8051 {
-		proxy /foo/(\w)/(.*) localhost:8079 {
-			policy random
-			timeout 1s
-		}
+	should := require.New(t)
+	c, err := inspect(t, `
+	:8080, :8051 {
+		proxy /foo/(\w)/(.*) localhost:8079
 	}
-	http://foo.com:8081 {
-		proxy /foo/(\w)/(.*) localhost:9931 {
-			policy random
-			timeout 5s
-		}
+	http://foo.com:8081, *.foo.com:8081 {
+		proxy /foo/(\w)/(.*) localhost:9931
+	}
+	bar.com:8081 {
+		proxy /bar localhost:9931
+	}
+	`)
+	should.NoError(err)
+	should.Len(c.cfg, 5)
+	for i, expect := range []struct {
+		addr, host, listen string
+		block              int
+	}{
+		{addr: ":8080", listen: ":8080"},
+		{addr: ":8051", listen: ":8051"},
+		{addr: "http://foo.com:8081", host: "foo.com", listen: ":8081", block: 1},
+		{addr: "*.foo.com:8081", host: "*.foo.com", listen: ":8081", block: 1},
+		{addr: "bar.com:8081", host: "bar.com", listen: ":8081", block: 2},
+	} {
+		cfg := c.cfg[i]
+		should.Equal(expect.addr, cfg.Addr)
+		should.Equal(expect.host, cfg.Host)
+		should.Equal(expect.listen, cfg.ListenAddr)
+		should.Equal(expect.block, cfg.block)
+		// tell the sites apart by the body of not found
+		c.cfg[i].NotFound.Body = cfg.Addr
+	}
+
+	servers, err := c.MakeServers()
+	should.NoError(err)
+	should.Len(servers, 3)
+	should.Equal(":8080", servers[0].(*FastServer).Address())
+	should.Equal(":8051", servers[1].(*FastServer).Address())
+	srv := servers[2].(*FastServer)
+	should.Equal(":8081", srv.Address())
+	for host, expect := range map[string]string{
+		"foo.com":       "http://foo.com:8081",
+		"FOO.com:8081":  "http://foo.com:8081",
+		"a.b.foo.com":   "*.foo.com:8081",
+		"bar.com:8081":  "bar.com:8081",
+		"unknown.com":   "http://foo.com:8081",
+		"127.0.0.1:801": "http://foo.com:8081",
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/")
+		ctx.Request.Header.SetHost(host)
+		srv.Handler(ctx)
+		should.Equal(expect, string(ctx.Response.Body()), host)
+	}
+}
+
+func TestVirtualHosts_Default(t *testing.T) {
+	should := require.New(t)
+	c, err := inspect(t, `
+	a.com:8080 {
+	}
+	:8080 {
+	}
+	`)
+	should.NoError(err)
+	for i := range c.cfg {
+		c.cfg[i].NotFound.Body = c.cfg[i].Addr
 	}
-	`
-	after, err := caddyfile.ToJSON([]byte(cfg))
+	servers, err := c.MakeServers()
+	should.NoError(err)
+	should.Len(servers, 1)
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetHost("b.com")
+	servers[0].(*FastServer).Handler(ctx)
+	should.Equal(":8080", string(ctx.Response.Body()))
+}
+
+func TestVirtualHosts_Error(t *testing.T) {
 	should := require.New(t)
+	_, err := inspect(t, "a.com:80 {\n}\nhttp://A.com {\n}")
+	should.Error(err)
+	_, err = inspect(t, ":80 {\n}\n*:80 {\n}")
+	should.Error(err)
+
+	c, err := inspect(t, "a.com:443 {\n}\nb.com:443 {\n}")
+	should.NoError(err)
+	c.cfg[0].TLS = &tls.Config{}
+	_, err = c.MakeServers()
+	should.Error(err)
+}
+
+func TestMakeServer_Options(t *testing.T) {
+	should := require.New(t)
+	c, err := inspect(t, "a.com:8080 {\n}\n:8080 {\n}")
+	should.NoError(err)
+	// the sites on an address share the options
+	for i := range c.cfg {
+		cfg := &c.cfg[i]
+		cfg.Name = "durian"
+		cfg.Concurrency = 1000
+		cfg.ReadBufferSize = 8 << 10
+		cfg.MaxRequestBodySize = 16 << 20
+		cfg.MaxConnsPerIP = 10
+		cfg.TCPKeepalive = true
+		cfg.TCPKeepalivePeriod = time.Minute
+		cfg.NoDefaultContentType = true
+	}
+	servers, err := c.MakeServers()
 	should.NoError(err)
-	should.FailNow("test", "%s", after)
+	srv := servers[0].(*FastServer)
+	should.Equal("durian", srv.Name)
+	should.Equal(1000, srv.Concurrency)
+	should.Equal(8<<10, srv.ReadBufferSize)
+	should.Equal(16<<20, srv.MaxRequestBodySize)
+	should.Equal(10, srv.MaxConnsPerIP)
+	should.True(srv.TCPKeepalive)
+	should.Equal(time.Minute, srv.TCPKeepalivePeriod)
+	should.True(srv.NoDefaultContentType)
+
+	info := srv.Info()
+	for _, line := range []string{
+		"server :8080\n",
+		"  sites                     a.com:8080, :8080\n",
+		"  name                      durian\n",
+		"  concurrency               1000\n",
+		"  keepalive                 on\n",
+		"  tcp_keepalive             1m0s\n",
+		"  read_timeout              unlimited\n",
+		"  read_buffer_size          8k\n",
+		"  write_buffer_size         4k\n",
+		"  max_request_body_size     16m\n",
+		"  max_conns_per_ip          10\n",
+		"  max_requests_per_conn     unlimited\n",
+		"  default_content_type      off\n",
+	} {
+		should.Contains(info, line)
+	}
+}
+
+func TestParseSiteAddr(t *testing.T) {
+	should := require.New(t)
+	testCases := []struct {
+		key, host, listen string
+		err               bool
+	}{
+		{key: ":8080", listen: ":8080"},
+		{key: "a.com", host: "a.com", listen: ":80"},
+		{key: "https://a.com", host: "a.com", listen: ":443"},
+		{key: "http://a.com:8080/", host: "a.com", listen: ":8080"},
+		{key: "*.a.com:8080", host: "*.a.com", listen: ":8080"},
+		{key: "*:8080", listen: ":8080"},
+		{key: "127.0.0.1:8080", listen: "127.0.0.1:8080"},
+		{key: "localhost:8080", listen: "localhost:8080"},
+		{key: "[::1]:8080", listen: "[::1]:8080"},
+		{key: "a.com:", err: true},
+		{key: "a.com/foo", err: true},
+	}
+	for _, tc := range testCases {
+		host, listen, err := parseSiteAddr(tc.key)
+		if tc.err {
+			should.Error(err, tc.key)
+			continue
+		}
+		should.NoError(err, tc.key)
+		should.Equal(tc.host, host, tc.key)
+		should.Equal(tc.listen, listen, tc.key)
+	}
 }
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	internalRedirectKey   = "_internal_redirect"
+	internalRedirectCount = "_internal_redirect_count"
+	originalRequestURIKey = "_original_request_uri"
+	// maxInternalRedirects is the same as nginx's
+	maxInternalRedirects = 10
+)
+
+var (
+	errNoRedirectHandler = errors.New("internal redirect isn't supported")
+	errRedirectCycle     = errors.New("rewrite or internal redirection cycle")
+)
+
+// withInternalRedirect makes InternalRedirect dispatch requests to handler
+func withInternalRedirect(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetUserValue(internalRedirectKey, handler)
+		handler(ctx)
+	}
+}
+
+// InternalRedirect serves the request again with uri by all the middlewares, like nginx's internal redirect.
+// The request is turned into GET(HEAD stays HEAD) without body, and the response is reset
+func InternalRedirect(ctx *fasthttp.RequestCtx, uri []byte) error {
+	handler, ok := ctx.UserValue(internalRedirectKey).(fasthttp.RequestHandler)
+	if !ok {
+		return errNoRedirectHandler
+	}
+	count, _ := ctx.UserValue(internalRedirectCount).(int)
+	if count >= maxInternalRedirects {
+		return errRedirectCycle
+	}
+	ctx.SetUserValue(internalRedirectCount, count+1)
+	if count == 0 {
+		// keep what the client asked for, for the access log
+		ctx.SetUserValue(originalRequestURIKey, append([]byte(nil), ctx.RequestURI()...))
+	}
+	ctx.Request.SetRequestURIBytes(uri)
+	if !ctx.IsHead() {
+		ctx.Request.Header.SetMethod("GET")
+	}
+	// the streamed body must be read off the connection before it's dropped
+	discardRequestBody(ctx)
+	ctx.Request.ResetBody()
+	ctx.Request.Header.SetContentLength(0)
+	ctx.Response.Reset()
+	handler(ctx)
+	return nil
+}
+
+// OriginalRequestURI returns the request uri sent by the client, which isn't changed by InternalRedirect
+func OriginalRequestURI(ctx *fasthttp.RequestCtx) []byte {
+	if uri, ok := ctx.UserValue(originalRequestURIKey).([]byte); ok {
+		return uri
+	}
+	return ctx.RequestURI()
+}
+
+// OriginalPath returns the path of OriginalRequestURI
+func OriginalPath(ctx *fasthttp.RequestCtx) []byte {
+	uri, ok := ctx.UserValue(originalRequestURIKey).([]byte)
+	if !ok {
+		return ctx.Path()
+	}
+	var u fasthttp.URI
+	u.Parse(nil, uri)
+	return u.Path()
+}
+
+// OriginalQueryString returns the query string of OriginalRequestURI
+func OriginalQueryString(ctx *fasthttp.RequestCtx) []byte {
+	uri := OriginalRequestURI(ctx)
+	if idx := bytes.IndexByte(uri, '?'); idx != -1 {
+		return uri[idx+1:]
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestInternalRedirect(t *testing.T) {
+	should := require.New(t)
+	var redirectErr error
+	handler := withInternalRedirect(func(ctx *fasthttp.RequestCtx) {
+		switch string(ctx.Path()) {
+		case "/app.php":
+			ctx.SetBodyString("dynamic")
+			redirectErr = InternalRedirect(ctx, []byte("/static/a.txt?v=1"))
+		case "/loop":
+			// the error is returned by the innermost redirect
+			if err := InternalRedirect(ctx, []byte("/loop")); err != nil {
+				redirectErr = err
+			}
+		default:
+			ctx.SetBodyString(string(ctx.Method()) + " " + string(ctx.RequestURI()))
+		}
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/app.php?id=1")
+	ctx.Request.SetBodyString("a=1")
+	should.Equal("/app.php?id=1", string(OriginalRequestURI(ctx)))
+	should.Equal("/app.php", string(OriginalPath(ctx)))
+	handler(ctx)
+	should.NoError(redirectErr)
+	should.Equal("GET /static/a.txt?v=1", string(ctx.Response.Body()))
+	should.Empty(ctx.Request.Body())
+	// the log shows what the client asked for
+	should.Equal("/static/a.txt?v=1", string(ctx.RequestURI()))
+	should.Equal("/app.php?id=1", string(OriginalRequestURI(ctx)))
+	should.Equal("/app.php", string(OriginalPath(ctx)))
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/loop")
+	handler(ctx)
+	should.Equal(errRedirectCycle, redirectErr)
+	should.Equal("/loop", string(OriginalRequestURI(ctx)))
+
+	should.Equal(errNoRedirectHandler, InternalRedirect(&fasthttp.RequestCtx{}, []byte("/")))
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// radixTree finds the inserted prefixes of a path, the cost depends on the length of the path
+// rather than the number of prefixes
+type radixTree struct {
+	root radixNode
+}
+
+type radixNode struct {
+	// label is the part of prefix from the parent to the node
+	label    string
+	values   []*Location
+	children []*radixNode
+	// indices[i] is the first byte of children[i].label
+	indices []byte
+}
+
+func (t *radixTree) insert(prefix string, value *Location) {
+	n := &t.root
+	for {
+		if prefix == "" {
+			n.values = append(n.values, value)
+			return
+		}
+		child := n.child(prefix[0])
+		if child == nil {
+			n.indices = append(n.indices, prefix[0])
+			n.children = append(n.children, &radixNode{label: prefix, values: []*Location{value}})
+			return
+		}
+		common := commonPrefix(prefix, child.label)
+		if common < len(child.label) {
+			// split child at common, the tail keeps the values and children
+			tail := &radixNode{
+				label:    child.label[common:],
+				values:   child.values,
+				children: child.children,
+				indices:  child.indices,
+			}
+			child.label = child.label[:common]
+			child.values = nil
+			child.children = []*radixNode{tail}
+			child.indices = []byte{tail.label[0]}
+		}
+		n, prefix = child, prefix[common:]
+	}
+}
+
+func (n *radixNode) child(c byte) *radixNode {
+	for i, idx := range n.indices {
+		if idx == c {
+			return n.children[i]
+		}
+	}
+	return nil
+}
+
+// next returns the child whose label is a prefix of path, nil if there's none
+func (n *radixNode) next(path []byte) *radixNode {
+	if len(path) == 0 {
+		return nil
+	}
+	child := n.child(path[0])
+	if child == nil || len(path) < len(child.label) || string(path[:len(child.label)]) != child.label {
+		return nil
+	}
+	return child
+}
+
+// longestPrefix returns the first value of the longest prefix of path, nil if there's none
+func (t *radixTree) longestPrefix(path []byte) *Location {
+	var found *Location
+	for n := &t.root; n != nil; n = n.next(path) {
+		path = path[len(n.label):]
+		if len(n.values) > 0 {
+			found = n.values[0]
+		}
+	}
+	return found
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// minCombined is the number of unanchored regular expressions from which they're combined,
+// checking fewer ones one by one is faster
+const minCombined = 8
+
+// regexpSet finds the first matched regular expression in the order they're added.
+// A regular expression like `^/user/(\d+)$` can only match the paths starting with /user/, so those
+// anchored ones are indexed by the literal prefix and only the ones whose prefix matches are checked.
+// Others can match anywhere, and most requests match none of them, which is told by the
+// combined `(?:re0)|(?:re1)|...` at once
+type regexpSet struct {
+	anchored radixTree
+	others   []*Location
+	combined *regexp.Regexp
+	// order of the locations
+	order map[*Location]int
+}
+
+func newRegexpSet(locations []*Location) *regexpSet {
+	s := &regexpSet{order: make(map[*Location]int, len(locations))}
+	for i, lo := range locations {
+		s.order[lo] = i
+		if prefix, ok := anchoredPrefix(lo.re); ok {
+			s.anchored.insert(prefix, lo)
+		} else {
+			s.others = append(s.others, lo)
+		}
+	}
+	if len(s.others) >= minCombined {
+		alternatives := make([]string, 0, len(s.others))
+		for _, lo := range s.others {
+			alternatives = append(alternatives, "(?:"+lo.re.String()+")")
+		}
+		// every alternative compiles, so does the combined one
+		s.combined = regexp.MustCompile(strings.Join(alternatives, "|"))
+	}
+	return s
+}
+
+// anchoredPrefix returns the literal following ^ of re, ok is false if re isn't anchored at the beginning
+func anchoredPrefix(re *regexp.Regexp) (prefix string, ok bool) {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	parsed = parsed.Simplify()
+	subs := []*syntax.Regexp{parsed}
+	if parsed.Op == syntax.OpConcat {
+		subs = parsed.Sub
+	}
+	if subs[0].Op != syntax.OpBeginText {
+		return "", false
+	}
+	var literal []rune
+	for _, sub := range subs[1:] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
+		}
+		literal = append(literal, sub.Rune...)
+	}
+	return string(literal), true
+}
+
+func (s *regexpSet) find(path []byte) *Location {
+	var found *Location
+	// the anchored candidates are the values of the nodes on the way to path
+	rest := path
+	for n := &s.anchored.root; n != nil; n = n.next(rest) {
+		rest = rest[len(n.label):]
+		for _, lo := range n.values {
+			if (found == nil || s.order[lo] < s.order[found]) && lo.re.Match(path) {
+				found = lo
+			}
+		}
+	}
+	if len(s.others) == 0 || s.combined != nil && !s.combined.Match(path) {
+		return found
+	}
+	for _, lo := range s.others {
+		if found != nil && s.order[lo] > s.order[found] {
+			break
+		}
+		if lo.re.Match(path) {
+			return lo
+		}
+	}
+	return found
+}
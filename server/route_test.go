@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestRadixTree(t *testing.T) {
+	should := require.New(t)
+	prefixes := []string{"/", "/api", "/api/v1", "/api/v1/users", "/api/v2", "/apix", "/static/", "/static/img", "/s"}
+	var tree radixTree
+	locations := make([]*Location, 0, len(prefixes))
+	for _, p := range prefixes {
+		lo, err := NewLocation(LocationPrefix, p)
+		should.NoError(err)
+		tree.insert(p, lo)
+		locations = append(locations, lo)
+	}
+	// compare with the linear search
+	longest := func(path []byte) *Location {
+		var found *Location
+		for _, lo := range locations {
+			if bytes.HasPrefix(path, []byte(lo.Pattern)) && (found == nil || len(lo.Pattern) > len(found.Pattern)) {
+				found = lo
+			}
+		}
+		return found
+	}
+	r := rand.New(rand.NewSource(1))
+	const alphabet = "/apiv12sxtcmgue"
+	for i := 0; i < 10000; i++ {
+		path := make([]byte, r.Intn(16))
+		for j := range path {
+			path[j] = alphabet[r.Intn(len(alphabet))]
+		}
+		should.Equal(longest(path), tree.longestPrefix(path), string(path))
+	}
+
+	var empty radixTree
+	should.Nil(empty.longestPrefix([]byte("/a")))
+}
+
+func TestRegexpSet(t *testing.T) {
+	should := require.New(t)
+	exprs := []string{
+		`\.php$`, `^/user/(\d+)$`, `(a)(b)(c)`, `^/img/.*\.(png|jpg)$`, `\.png$`, `/v\d/`, `^/$`, `x{3}`,
+		`^/static/`, `^/st`, `^/img/a`, `\.css$`, `^(/a|/b)c`, `\.js$`, `\.PHP$`,
+	}
+	var locations []*Location
+	for i, expr := range exprs {
+		modifier := LocationRegex
+		if i == len(exprs)-1 {
+			modifier = LocationRegexCaseless
+		}
+		lo, err := NewLocation(modifier, expr)
+		should.NoError(err)
+		locations = append(locations, lo)
+	}
+	set := newRegexpSet(locations)
+	should.NotNil(set.combined)
+	linear := func(path []byte) *Location {
+		for _, lo := range locations {
+			if lo.re.Match(path) {
+				return lo
+			}
+		}
+		return nil
+	}
+	for _, path := range []string{
+		"/index.php", "/INDEX.PHP", "/user/12", "/user/12.php", "/abc", "/img/a.png", "/img/a.css", "/a.png", "/api/v1/x",
+		"/", "/xxx", "/a.js", "/static/a.css", "/static/a.png", "/style", "/ac", "/bc", "/none",
+	} {
+		should.Equal(linear([]byte(path)), set.find([]byte(path)), path)
+	}
+	should.Equal(locations[3], set.find([]byte("/img/a.png")))
+	should.Equal(locations[len(locations)-1], set.find([]byte("/a.Php")))
+	should.Nil(set.find([]byte("/none")))
+}
+
+func TestAnchoredPrefix(t *testing.T) {
+	should := require.New(t)
+	testCases := []struct {
+		expr     string
+		prefix   string
+		anchored bool
+	}{
+		{expr: `^/user/(\d+)$`, prefix: "/user/", anchored: true},
+		{expr: `^/static`, prefix: "/static", anchored: true},
+		{expr: `^(/a|/b)c`, prefix: "", anchored: true},
+		{expr: `(?i)^/static`, prefix: "", anchored: true},
+		{expr: `^/ab|^/ac`, anchored: false},
+		{expr: `^/ab|/ac`, anchored: false},
+		{expr: `\.php$`, anchored: false},
+	}
+	for _, tc := range testCases {
+		prefix, ok := anchoredPrefix(regexp.MustCompile(tc.expr))
+		should.Equal(tc.anchored, ok, tc.expr)
+		should.Equal(tc.prefix, prefix, tc.expr)
+	}
+}
+
+// benchmarkRoutes dispatches requests among n prefix routes and n/10 regexp routes
+func benchmarkRoutes(b *testing.B, n int, useLocations bool) {
+	final := func(ctx *fasthttp.RequestCtx) {}
+	respond := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {}
+	}
+	cfg := &ServerConfig{}
+	var handler fasthttp.RequestHandler
+	if useLocations {
+		for i := 0; i < n; i++ {
+			lo, _ := NewLocation(LocationPrefix, fmt.Sprintf("/api/service%d/", i))
+			_ = cfg.AddLocation(lo, func() error {
+				cfg.AddMiddleware(respond)
+				return nil
+			})
+		}
+		for i := 0; i < n/10; i++ {
+			lo, _ := NewLocation(LocationRegex, fmt.Sprintf(`^/user%d/\d+$`, i))
+			_ = cfg.AddLocation(lo, func() error {
+				cfg.AddMiddleware(respond)
+				return nil
+			})
+		}
+		handler = newLocationMiddleware(cfg.locations, final)(final)
+	} else {
+		// the directives outside location blocks, each of which checks its own pattern
+		var middlewares []Middleware
+		for i := 0; i < n; i++ {
+			m, _ := NewLocationMatcher([]string{fmt.Sprintf("/api/service%d/", i)})
+			middlewares = append(middlewares, matchMiddleware(m))
+		}
+		for i := 0; i < n/10; i++ {
+			m, _ := NewLocationMatcher([]string{"~", fmt.Sprintf(`^/user%d/\d+$`, i)})
+			middlewares = append(middlewares, matchMiddleware(m))
+		}
+		handler = compileMiddleware(middlewares, final)
+	}
+	paths := make([]*fasthttp.RequestCtx, 0, 64)
+	for i := 0; i < 64; i++ {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(fmt.Sprintf("/api/service%d/users/1", i*n/64))
+		paths = append(paths, ctx)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler(paths[i%len(paths)])
+	}
+}
+
+func matchMiddleware(m LocationMatcher) Middleware {
+	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			if !m.Match(ctx) {
+				next(ctx)
+			}
+		}
+	}
+}
+
+func BenchmarkLocations(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("locations/%d", n), func(b *testing.B) { benchmarkRoutes(b, n, true) })
+		b.Run(fmt.Sprintf("middlewares/%d", n), func(b *testing.B) { benchmarkRoutes(b, n, false) })
+	}
+}
+
+// BenchmarkUnanchoredRegexps dispatches requests matching none of n regexp locations like `\.ext1$`
+func BenchmarkUnanchoredRegexps(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			var locations []*Location
+			for i := 0; i < n; i++ {
+				lo, _ := NewLocation(LocationRegex, fmt.Sprintf(`\.ext%d$`, i))
+				locations = append(locations, lo)
+			}
+			tree := newLocationTree(locations)
+			path := []byte("/api/service/users/1")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tree.find(path)
+			}
+		})
+	}
+}
@@ -1,7 +1,12 @@
 package server
 
 import (
+	"crypto/tls"
+	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mholt/caddy"
 	"github.com/valyala/fasthttp"
@@ -10,17 +15,46 @@ import (
 // make sure FastServer implement GracefulServer
 var _ caddy.GracefulServer = new(FastServer)
 
-func NewFastServer(cfg ServerConfig) *FastServer {
+// make sure FastServer dumps its info at startup
+var _ caddy.AfterStartup = new(FastServer)
+
+// NewFastServer returns the server listening on addr, which serves the sites by Host.
+// The options of fasthttp.Server are taken from the default site, the ones of the server directive must be the same for all sites
+func NewFastServer(addr string, sites []*ServerConfig) (*FastServer, error) {
+	hosts := newVirtualHosts(sites)
+	if err := hosts.checkOptions(addr, sites); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := hosts.tlsConfig(addr, sites)
+	if err != nil {
+		return nil, err
+	}
+	handler := hosts.Handle
+	if len(sites) == 1 {
+		handler = hosts.fallback.handler
+	}
 	srv := &FastServer{
-		Addr:   cfg.Addr,
-		Server: cfg.makeServer(),
+		Addr:      addr,
+		Server:    hosts.fallback.cfg.makeServer(handler),
+		TLSConfig: tlsConfig,
+		sites:     sites,
+	}
+	for _, cfg := range sites {
+		if len(cfg.streamLocations) > 0 {
+			// bodies of other sites are read by withRequestBody, multipart ones included
+			srv.StreamRequestBody = true
+			srv.DisablePreParseMultipartForm = true
+		}
 	}
-	return srv
+	return srv, nil
 }
 
 type FastServer struct {
 	*fasthttp.Server
 	Addr string
+	// TLSConfig is nil unless the tls directive is used in the server block
+	TLSConfig *tls.Config
+	sites     []*ServerConfig
 }
 
 func (s *FastServer) Listen() (net.Listener, error) {
@@ -28,18 +62,26 @@ func (s *FastServer) Listen() (net.Listener, error) {
 	if err != nil {
 		return nil, err
 	}
+	return s.WrapListener(ln), nil
+}
+
+// WrapListener is also called by caddy with the inherited listener on graceful restart,
+// so certificates loaded by the new instance take effect without closing the socket
+func (s *FastServer) WrapListener(ln net.Listener) net.Listener {
+	if ln == nil {
+		return nil
+	}
 	if s.TCPKeepalive {
 		if tcpln, ok := ln.(*net.TCPListener); ok {
-			return &tcpKeepaliveListener{
+			ln = &tcpKeepaliveListener{
 				TCPListener:     tcpln,
 				keepalivePeriod: s.TCPKeepalivePeriod,
-			}, nil
+			}
 		}
 	}
-	return ln, nil
-}
-
-func (s *FastServer) WrapListener(ln net.Listener) net.Listener {
+	if s.TLSConfig != nil {
+		ln = newTLSListener(ln, s.TLSConfig)
+	}
 	return ln
 }
 
@@ -62,3 +104,120 @@ func (s *FastServer) Address() string {
 func (s *FastServer) Stop() error {
 	return s.Server.Shutdown()
 }
+
+// OnStartupComplete prints the info of the server unless caddy is quiet
+func (s *FastServer) OnStartupComplete() {
+	if !caddy.Quiet {
+		fmt.Print(s.Info())
+	}
+}
+
+// Info dumps the address, sites and options of the server, zero options are shown as the defaults of fasthttp
+func (s *FastServer) Info() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "server %s", s.Addr)
+	if s.TLSConfig != nil {
+		b.WriteString(" (tls)")
+	}
+	b.WriteString("\n")
+	keys := make([]string, 0, len(s.sites))
+	for _, cfg := range s.sites {
+		keys = append(keys, cfg.Addr)
+	}
+	name := s.Name
+	if s.NoDefaultServerHeader {
+		name = "none"
+	} else if name == "" {
+		name = "fasthttp"
+	}
+	concurrency := s.Concurrency
+	if concurrency == 0 {
+		concurrency = fasthttp.DefaultConcurrency
+	}
+	for _, opt := range [][2]string{
+		{"sites", strings.Join(keys, ", ")},
+		{"name", name},
+		{"concurrency", strconv.Itoa(concurrency)},
+		{"keepalive", onOff(!s.DisableKeepalive)},
+		{"max_keepalive_duration", infoDuration(s.MaxKeepaliveDuration)},
+		{"tcp_keepalive", infoTCPKeepalive(s.TCPKeepalive, s.TCPKeepalivePeriod)},
+		{"read_timeout", infoDuration(s.ReadTimeout)},
+		{"write_timeout", infoDuration(s.WriteTimeout)},
+		{"read_buffer_size", infoSize(s.ReadBufferSize, "4k")},
+		{"write_buffer_size", infoSize(s.WriteBufferSize, "4k")},
+		{"max_request_body_size", infoSize(s.MaxRequestBodySize, infoSize(fasthttp.DefaultMaxRequestBodySize, ""))},
+		{"max_conns_per_ip", infoLimit(s.MaxConnsPerIP)},
+		{"max_requests_per_conn", infoLimit(s.MaxRequestsPerConn)},
+		{"header_names_normalizing", onOff(!s.DisableHeaderNamesNormalizing)},
+		{"default_content_type", onOff(!s.NoDefaultContentType)},
+	} {
+		fmt.Fprintf(&b, "  %-25s %s\n", opt[0], opt[1])
+	}
+	return b.String()
+}
+
+// serverOptions returns the options set by the server directive in the format of Info
+func (cfg *ServerConfig) serverOptions() [][2]string {
+	name, concurrency := cfg.Name, "default"
+	if name == "" {
+		name = "default"
+	}
+	if cfg.Concurrency > 0 {
+		concurrency = strconv.Itoa(cfg.Concurrency)
+	}
+	return [][2]string{
+		{"name", name},
+		{"concurrency", concurrency},
+		{"keepalive", onOff(!cfg.DisableKeepalive)},
+		{"tcp_keepalive", infoTCPKeepalive(cfg.TCPKeepalive, cfg.TCPKeepalivePeriod)},
+		{"read_buffer_size", infoSize(cfg.ReadBufferSize, "default")},
+		{"write_buffer_size", infoSize(cfg.WriteBufferSize, "default")},
+		{"max_request_body_size", infoSize(cfg.MaxRequestBodySize, "default")},
+		{"max_conns_per_ip", infoLimit(cfg.MaxConnsPerIP)},
+		{"max_requests_per_conn", infoLimit(cfg.MaxRequestsPerConn)},
+		{"disable_header_names_normalizing", onOff(cfg.DisableHeaderNamesNormalizing)},
+		{"no_default_server_header", onOff(cfg.NoDefaultServerHeader)},
+		{"no_default_content_type", onOff(cfg.NoDefaultContentType)},
+	}
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func infoDuration(d time.Duration) string {
+	if d == 0 {
+		return "unlimited"
+	}
+	return d.String()
+}
+
+func infoTCPKeepalive(on bool, period time.Duration) string {
+	if !on || period == 0 {
+		return onOff(on)
+	}
+	return period.String()
+}
+
+func infoLimit(n int) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(n)
+}
+
+// infoSize formats size like 64k or 8m, and returns def for zero
+func infoSize(size int, def string) string {
+	switch {
+	case size == 0:
+		return def
+	case size%(1<<20) == 0:
+		return strconv.Itoa(size>>20) + "m"
+	case size%(1<<10) == 0:
+		return strconv.Itoa(size>>10) + "k"
+	}
+	return strconv.Itoa(size)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/caibirdme/durian/replace"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	upstreamTriesKey = "__durian_upstream_tries"
+
+	VarUpstreamAddr         = "upstream_addr"
+	VarUpstreamStatus       = "upstream_status"
+	VarUpstreamConnectTime  = "upstream_connect_time"
+	VarUpstreamHeaderTime   = "upstream_header_time"
+	VarUpstreamResponseTime = "upstream_response_time"
+)
+
+func init() {
+	for _, name := range []string{VarUpstreamAddr, VarUpstreamStatus, VarUpstreamConnectTime, VarUpstreamHeaderTime, VarUpstreamResponseTime} {
+		name := name
+		replace.RegisterPlaceholder(name, func(ctx *fasthttp.RequestCtx, w io.Writer) (int, error) {
+			return w.Write(AppendUpstreamVar(nil, ctx, name))
+		})
+	}
+}
+
+// UpstreamTry records a try of passing the request to a backend,
+// Status is 0 if there's no response and the durations are negative if they're unknown
+type UpstreamTry struct {
+	Addr         string
+	Status       int
+	ConnectTime  time.Duration
+	HeaderTime   time.Duration
+	ResponseTime time.Duration
+}
+
+// AddUpstreamTry is called by proxy and fastcgi after each try
+func AddUpstreamTry(ctx *fasthttp.RequestCtx, try UpstreamTry) {
+	tries, _ := ctx.UserValue(upstreamTriesKey).([]UpstreamTry)
+	ctx.SetUserValue(upstreamTriesKey, append(tries, try))
+}
+
+// GetUpstreamTries returns all the tries of the request
+func GetUpstreamTries(ctx *fasthttp.RequestCtx) []UpstreamTry {
+	tries, _ := ctx.UserValue(upstreamTriesKey).([]UpstreamTry)
+	return tries
+}
+
+// AppendUpstreamVar appends the variable of all the tries separated by ", " like nginx,
+// durations are in seconds with millisecond resolution. "-" is appended if there's no upstream
+func AppendUpstreamVar(dst []byte, ctx *fasthttp.RequestCtx, name string) []byte {
+	tries := GetUpstreamTries(ctx)
+	if len(tries) == 0 {
+		return append(dst, '-')
+	}
+	for i, try := range tries {
+		if i > 0 {
+			dst = append(dst, ", "...)
+		}
+		switch name {
+		case VarUpstreamAddr:
+			dst = append(dst, try.Addr...)
+		case VarUpstreamStatus:
+			if try.Status == 0 {
+				dst = append(dst, '-')
+			} else {
+				dst = strconv.AppendInt(dst, int64(try.Status), 10)
+			}
+		case VarUpstreamConnectTime:
+			dst = appendSeconds(dst, try.ConnectTime)
+		case VarUpstreamHeaderTime:
+			dst = appendSeconds(dst, try.HeaderTime)
+		case VarUpstreamResponseTime:
+			dst = appendSeconds(dst, try.ResponseTime)
+		}
+	}
+	return dst
+}
+
+func appendSeconds(dst []byte, d time.Duration) []byte {
+	if d < 0 {
+		return append(dst, '-')
+	}
+	return strconv.AppendFloat(dst, d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestAppendUpstreamVar(t *testing.T) {
+	should := require.New(t)
+	var ctx fasthttp.RequestCtx
+	should.Equal("-", string(AppendUpstreamVar(nil, &ctx, VarUpstreamResponseTime)))
+	AddUpstreamTry(&ctx, UpstreamTry{Addr: "unix:/tmp/php.sock", ConnectTime: -1, ResponseTime: 1500 * time.Millisecond})
+	AddUpstreamTry(&ctx, UpstreamTry{Addr: "10.0.0.1:9000", Status: 200, ConnectTime: time.Millisecond, ResponseTime: 12 * time.Millisecond})
+	should.Equal("unix:/tmp/php.sock, 10.0.0.1:9000", string(AppendUpstreamVar(nil, &ctx, VarUpstreamAddr)))
+	should.Equal("-, 200", string(AppendUpstreamVar(nil, &ctx, VarUpstreamStatus)))
+	should.Equal("-, 0.001", string(AppendUpstreamVar(nil, &ctx, VarUpstreamConnectTime)))
+	should.Equal("1.500, 0.012", string(AppendUpstreamVar(nil, &ctx, VarUpstreamResponseTime)))
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// parseSiteAddr parses the key of server block, such as a.com:80, *.a.com:8080, :8080 or https://a.com.
+// The port is 80 by default, and 443 for https://. Sites of ip or localhost are bound to it and have no server name
+func parseSiteAddr(key string) (host, listen string, err error) {
+	port := "80"
+	addr := key
+	if strings.HasPrefix(addr, "https://") {
+		port = "443"
+		addr = addr[len("https://"):]
+	} else if strings.HasPrefix(addr, "http://") {
+		addr = addr[len("http://"):]
+	}
+	addr = strings.TrimSuffix(addr, "/")
+	if h, p, err := net.SplitHostPort(addr); err == nil {
+		host, port = h, p
+	} else {
+		host = addr
+	}
+	if port == "" || strings.ContainsAny(host, "/ ") {
+		return "", "", fmt.Errorf("invalid site address %s", key)
+	}
+	host = strings.ToLower(host)
+	if host == "*" {
+		host = ""
+	}
+	if host == "localhost" || net.ParseIP(host) != nil {
+		return "", net.JoinHostPort(host, port), nil
+	}
+	return host, ":" + port, nil
+}
+
+// virtualHosts dispatches requests by Host header without port, exact names are checked first,
+// then the wildcards like *.a.com from the longest, and the default site serves the rest
+type virtualHosts struct {
+	exact     map[string]*site
+	wildcards []*site
+	fallback  *site
+}
+
+type site struct {
+	cfg     *ServerConfig
+	handler fasthttp.RequestHandler
+	// suffix is like .a.com for wildcard *.a.com
+	suffix string
+}
+
+// newVirtualHosts uses the site without server name as the default one, or the first site if there's none
+func newVirtualHosts(sites []*ServerConfig) *virtualHosts {
+	v := &virtualHosts{exact: make(map[string]*site)}
+	var first *site
+	for _, cfg := range sites {
+		s := &site{cfg: cfg, handler: cfg.makeHandler()}
+		if first == nil {
+			first = s
+		}
+		switch {
+		case cfg.Host == "":
+			v.fallback = s
+		case strings.HasPrefix(cfg.Host, "*."):
+			s.suffix = cfg.Host[1:]
+			v.wildcards = append(v.wildcards, s)
+		default:
+			v.exact[cfg.Host] = s
+		}
+	}
+	sort.SliceStable(v.wildcards, func(i, j int) bool {
+		return len(v.wildcards[i].suffix) > len(v.wildcards[j].suffix)
+	})
+	if v.fallback == nil {
+		v.fallback = first
+	}
+	return v
+}
+
+func (v *virtualHosts) find(host string) *site {
+	if s, ok := v.exact[host]; ok {
+		return s
+	}
+	for _, s := range v.wildcards {
+		if strings.HasSuffix(host, s.suffix) {
+			return s
+		}
+	}
+	return v.fallback
+}
+
+func (v *virtualHosts) Handle(ctx *fasthttp.RequestCtx) {
+	v.find(hostname(ctx.Host())).handler(ctx)
+}
+
+// hostname lowers host and strips the port
+func hostname(host []byte) string {
+	h := strings.ToLower(string(host))
+	if name, _, err := net.SplitHostPort(h); err == nil {
+		return name
+	}
+	return h
+}
+
+// tlsConfig selects the tls config of sites by SNI, the sites on an address must all use tls or not
+func (v *virtualHosts) tlsConfig(addr string, sites []*ServerConfig) (*tls.Config, error) {
+	for _, cfg := range sites {
+		if (cfg.TLS == nil) != (sites[0].TLS == nil) {
+			return nil, fmt.Errorf("sites on %s must all use tls or not, %s doesn't match %s", addr, cfg.Addr, sites[0].Addr)
+		}
+	}
+	if len(sites) == 1 || v.fallback.cfg.TLS == nil {
+		return v.fallback.cfg.TLS, nil
+	}
+	config := v.fallback.cfg.TLS.Clone()
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		return v.find(strings.ToLower(hello.ServerName)).cfg.TLS, nil
+	}
+	return config, nil
+}
+
+// checkOptions makes sure the sites on an address set the same options of fasthttp.Server by the server directive,
+// since they share a fasthttp.Server
+func (v *virtualHosts) checkOptions(addr string, sites []*ServerConfig) error {
+	def := v.fallback.cfg
+	expect := def.serverOptions()
+	for _, cfg := range sites {
+		for i, opt := range cfg.serverOptions() {
+			if opt[1] != expect[i][1] {
+				return fmt.Errorf("sites on %s share the server options, but %s of %s is %s while it's %s of the default site %s",
+					addr, opt[0], cfg.Addr, opt[1], expect[i][1], def.Addr)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package server_options
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/mholt/caddy"
+)
+
+const (
+	pluginName = "server"
+)
+
+func init() {
+	caddy.RegisterPlugin(super.DirectiveServer, caddy.Plugin{
+		ServerType: super.FastHTTPServerType,
+		Action:     setup,
+	})
+}
+
+func setup(c *caddy.Controller) error {
+	cfg := super.GetConfig(c)
+	if cfg == nil {
+		return c.Errf("[%s] couldn't find %s's config", pluginName, c.Key)
+	}
+	return parseOptions(c, cfg)
+}
+
+// parseOptions sets the options of fasthttp.Server, each of them can only be set once
+func parseOptions(c *caddy.Controller, cfg *super.ServerConfig) error {
+	seen := make(map[string]bool)
+	for c.Next() {
+		if len(c.RemainingArgs()) > 0 {
+			return c.ArgErr()
+		}
+		for c.NextBlock() {
+			name := strings.ToLower(c.Val())
+			args := c.RemainingArgs()
+			if seen[name] {
+				return c.Errf("[%s] %s is set more than once", pluginName, name)
+			}
+			seen[name] = true
+			if err := parseOption(c, cfg, name, args); err != nil {
+				return err
+			}
+		}
+	}
+	if seen["name"] && cfg.NoDefaultServerHeader {
+		return c.Errf("[%s] name is useless with no_default_server_header", pluginName)
+	}
+	if cfg.DisableKeepalive && cfg.MaxRequestsPerConn > 0 {
+		return c.Errf("[%s] max_requests_per_conn is useless with keepalive off", pluginName)
+	}
+	return nil
+}
+
+func parseOption(c *caddy.Controller, cfg *super.ServerConfig, name string, args []string) error {
+	switch name {
+	case "disable_header_names_normalizing", "no_default_server_header", "no_default_content_type":
+		on, err := parseFlag(args)
+		if err != nil {
+			return c.Errf("[%s] %s %s", pluginName, name, err)
+		}
+		switch name {
+		case "disable_header_names_normalizing":
+			cfg.DisableHeaderNamesNormalizing = on
+		case "no_default_server_header":
+			cfg.NoDefaultServerHeader = on
+		default:
+			cfg.NoDefaultContentType = on
+		}
+		return nil
+	case "tcp_keepalive":
+		return parseTCPKeepalive(c, cfg, args)
+	}
+	if len(args) != 1 {
+		return c.Errf("[%s] %s expects exactly one value, got %d", pluginName, name, len(args))
+	}
+	val := args[0]
+	switch name {
+	case "name":
+		cfg.Name = val
+	case "root":
+		root, err := filepath.Abs(val)
+		if err != nil {
+			return c.Errf("[%s] invalid root %s: %s", pluginName, val, err)
+		}
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			return c.Errf("[%s] root %s isn't a directory", pluginName, val)
+		}
+		cfg.Root = root
+	case "keepalive":
+		on, err := parseFlag(args)
+		if err != nil {
+			return c.Errf("[%s] keepalive %s", pluginName, err)
+		}
+		cfg.DisableKeepalive = !on
+	case "concurrency", "max_conns_per_ip", "max_requests_per_conn":
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 {
+			return c.Errf("[%s] %s should be a positive integer but %s", pluginName, name, val)
+		}
+		switch name {
+		case "concurrency":
+			cfg.Concurrency = n
+		case "max_conns_per_ip":
+			cfg.MaxConnsPerIP = n
+		default:
+			cfg.MaxRequestsPerConn = n
+		}
+	case "read_buffer_size", "write_buffer_size", "max_request_body_size":
+		size, err := super.ParseSize(val)
+		if err != nil || size <= 0 {
+			return c.Errf("[%s] %s should be a positive size like 4096, 64k or 8m but %s", pluginName, name, val)
+		}
+		switch name {
+		case "read_buffer_size":
+			cfg.ReadBufferSize = size
+		case "write_buffer_size":
+			cfg.WriteBufferSize = size
+		default:
+			cfg.MaxRequestBodySize = size
+		}
+	default:
+		return c.Errf("[%s] unknown option %s", pluginName, name)
+	}
+	return nil
+}
+
+// parseTCPKeepalive accepts on, off or the period of keepalive probes, which turns it on
+func parseTCPKeepalive(c *caddy.Controller, cfg *super.ServerConfig, args []string) error {
+	if on, err := parseFlag(args); err == nil {
+		cfg.TCPKeepalive = on
+		return nil
+	}
+	if len(args) != 1 {
+		return c.Errf("[%s] tcp_keepalive expects on, off or a period", pluginName)
+	}
+	d, err := time.ParseDuration(args[0])
+	if err != nil || d <= 0 {
+		return c.Errf("[%s] tcp_keepalive should be on, off or a positive duration like 30s but %s", pluginName, args[0])
+	}
+	cfg.TCPKeepalive = true
+	cfg.TCPKeepalivePeriod = d
+	return nil
+}
+
+// parseFlag returns true for no argument, and accepts on/off besides bool literals
+func parseFlag(args []string) (bool, error) {
+	if len(args) == 0 {
+		return true, nil
+	}
+	if len(args) > 1 {
+		return false, fmt.Errorf("should be on or off but %s", strings.Join(args, " "))
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	on, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return false, fmt.Errorf("should be on or off but %s", args[0])
+	}
+	return on, nil
+}
@@ -0,0 +1,132 @@
+package server_options
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyfile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOptions(t *testing.T) {
+	should := require.New(t)
+	root := os.TempDir()
+	c := caddy.NewTestController(super.FastHTTPServerType, `server {
+		name durian
+		concurrency 1000
+		keepalive on
+		root `+root+`
+		read_buffer_size 8k
+		write_buffer_size 16384
+		max_conns_per_ip 100
+		max_requests_per_conn 1000
+		max_request_body_size 8m
+		tcp_keepalive 30s
+		disable_header_names_normalizing
+		no_default_content_type on
+	}`)
+	var cfg super.ServerConfig
+	should.NoError(parseOptions(c, &cfg))
+	should.Equal(super.ServerConfig{
+		Name:                          "durian",
+		Concurrency:                   1000,
+		Root:                          root,
+		ReadBufferSize:                8 * 1024,
+		WriteBufferSize:               16384,
+		MaxConnsPerIP:                 100,
+		MaxRequestsPerConn:            1000,
+		MaxRequestBodySize:            8 * 1024 * 1024,
+		TCPKeepalive:                  true,
+		TCPKeepalivePeriod:            30 * time.Second,
+		DisableHeaderNamesNormalizing: true,
+		NoDefaultContentType:          true,
+	}, cfg)
+
+	c = caddy.NewTestController(super.FastHTTPServerType, "server {\n keepalive off\n tcp_keepalive on\n no_default_server_header\n}")
+	cfg = super.ServerConfig{}
+	should.NoError(parseOptions(c, &cfg))
+	should.True(cfg.DisableKeepalive)
+	should.True(cfg.TCPKeepalive)
+	should.Zero(cfg.TCPKeepalivePeriod)
+	should.True(cfg.NoDefaultServerHeader)
+}
+
+func TestParseOptions_Error(t *testing.T) {
+	should := require.New(t)
+	for _, input := range []string{
+		"server /a {\n}",
+		"server {\n unknown 1\n}",
+		"server {\n concurrency\n}",
+		"server {\n concurrency 0\n}",
+		"server {\n concurrency 1k\n}",
+		"server {\n concurrency 10\n concurrency 20\n}",
+		"server {\n concurrency 10\n}\nserver {\n concurrency 20\n}",
+		"server {\n keepalive maybe\n}",
+		"server {\n read_buffer_size -1\n}",
+		"server {\n max_request_body_size 4x\n}",
+		"server {\n tcp_keepalive -1s\n}",
+		"server {\n tcp_keepalive on 30s\n}",
+		"server {\n no_default_content_type on off\n}",
+		"server {\n root /path/not/exist\n}",
+		"server {\n name a b\n}",
+		"server {\n name durian\n no_default_server_header\n}",
+		"server {\n keepalive off\n max_requests_per_conn 10\n}",
+	} {
+		c := caddy.NewTestController(super.FastHTTPServerType, input)
+		err := parseOptions(c, &super.ServerConfig{})
+		should.Error(err, input)
+	}
+}
+
+// makeServers sets up the server directives of input like caddy does, and makes the servers
+func makeServers(t *testing.T, input string) ([]caddy.Server, error) {
+	c := caddy.NewTestController(super.FastHTTPServerType, "")
+	sblocks, err := caddyfile.Parse("Testfile", strings.NewReader(input), nil)
+	require.NoError(t, err)
+	_, err = c.Context().InspectServerBlocks("Testfile", sblocks)
+	require.NoError(t, err)
+	for i, sblock := range sblocks {
+		for _, key := range sblock.Keys {
+			c.Key, c.ServerBlockIndex = key, i
+			c.Dispenser = caddyfile.NewDispenserTokens("Testfile", sblock.Tokens[super.DirectiveServer])
+			require.NoError(t, setup(c))
+		}
+	}
+	return c.Context().MakeServers()
+}
+
+func TestServerOptions_SharedByAddress(t *testing.T) {
+	should := require.New(t)
+	servers, err := makeServers(t, `
+	a.com:8080, :8080 {
+		server {
+			concurrency 100
+		}
+	}
+	b.com:8080 {
+		server {
+			concurrency 100
+		}
+	}
+	:8081 {
+		server {
+			concurrency 10
+		}
+	}`)
+	should.NoError(err)
+	should.Len(servers, 2)
+
+	_, err = makeServers(t, `
+	a.com:8080 {
+		server {
+			concurrency 100
+		}
+	}
+	:8080 {
+	}`)
+	should.EqualError(err, "sites on :8080 share the server options, but concurrency of a.com:8080 is 100 while it's default of the default site :8080")
+}
@@ -36,7 +36,7 @@ func setup(c *caddy.Controller) error {
 	process := fs.NewRequestHandler()
 	srvCfg.AddMiddleware(func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 		return func(ctx *fasthttp.RequestCtx) {
-			if !cfg.location.Match(ctx.Path()) {
+			if !cfg.location.Match(ctx) {
 				next(ctx)
 			} else {
 				process(ctx)
@@ -58,7 +58,7 @@ func parseStatic(c *caddy.Controller, cfg *StaticConfig) error {
 	// skip root
 	c.Next()
 
-	location, err := super.NewLocationMatcher(c.RemainingArgs())
+	location, err := super.NewMatcher(c, c.RemainingArgs())
 	if err != nil {
 		return c.Err(err.Error())
 	}
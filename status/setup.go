@@ -21,7 +21,7 @@ func setup(c *caddy.Controller) error {
 	}
 	super.GetConfig(c).AddMiddleware(func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 		return func(ctx *fasthttp.RequestCtx) {
-			if cfg.location.Match(ctx.Path()) {
+			if cfg.location.Match(ctx) {
 				ctx.SetStatusCode(cfg.Code)
 			}
 			next(ctx)
@@ -41,7 +41,7 @@ func parseStatus(c *caddy.Controller) (*StatusConfig, error) {
 
 	firstLine := c.RemainingArgs()
 	n := len(firstLine)
-	location, err := super.NewLocationMatcher(firstLine[:n-1])
+	location, err := super.NewMatcher(c, firstLine[:n-1])
 	if err != nil {
 		return nil, c.Err(err.Error())
 	}
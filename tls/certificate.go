@@ -0,0 +1,152 @@
+package tls
+
+import (
+	gotls "crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errNoCertificate = errors.New("no certificate found")
+	certExts         = []string{".crt", ".pem", ".cer"}
+	defaultKeyExt    = ".key"
+)
+
+// NewTLSConfig loads all the certificates and builds a *tls.Config
+// which selects certificate by SNI
+func NewTLSConfig(cfg *TLSConfig) (*gotls.Config, error) {
+	store := newCertStore()
+	if cfg.CertFile != "" {
+		if err := store.load(cfg.CertFile, cfg.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.CertDir != "" {
+		if err := store.loadDir(cfg.CertDir); err != nil {
+			return nil, err
+		}
+	}
+	if len(store.certs) == 0 {
+		return nil, errNoCertificate
+	}
+	tlsCfg := &gotls.Config{
+		GetCertificate:           store.getCertificate,
+		MinVersion:               cfg.MinVersion,
+		CipherSuites:             cfg.CipherSuites,
+		PreferServerCipherSuites: len(cfg.CipherSuites) > 0,
+		ClientAuth:               cfg.ClientAuth,
+		NextProtos:               []string{"http/1.1"},
+	}
+	if cfg.ClientCA != "" {
+		pool, err := loadCertPool(cfg.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.ClientCAs = pool
+	}
+	return tlsCfg, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s contains no valid certificate", caFile)
+	}
+	return pool, nil
+}
+
+// certStore indexes certificates by the names they're valid for
+type certStore struct {
+	certs  []*gotls.Certificate
+	byName map[string]*gotls.Certificate
+}
+
+func newCertStore() *certStore {
+	return &certStore{byName: make(map[string]*gotls.Certificate)}
+}
+
+func (s *certStore) load(certFile, keyFile string) error {
+	cert, err := gotls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("load %s: %s", certFile, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parse %s: %s", certFile, err)
+	}
+	cert.Leaf = leaf
+	s.certs = append(s.certs, &cert)
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		// the first certificate wins if names are duplicated
+		if _, ok := s.byName[name]; !ok {
+			s.byName[name] = &cert
+		}
+	}
+	return nil
+}
+
+// loadDir loads every foo.crt(.pem, .cer) with its foo.key in dir
+func (s *certStore) loadDir(dir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(info.Name())
+		if !isCertExt(ext) {
+			continue
+		}
+		certFile := filepath.Join(dir, info.Name())
+		keyFile := strings.TrimSuffix(certFile, ext) + defaultKeyExt
+		if _, err := os.Stat(keyFile); err != nil {
+			// a pem file may be the key itself or a bundle without a key
+			continue
+		}
+		if err := s.load(certFile, keyFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isCertExt(ext string) bool {
+	for _, e := range certExts {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// getCertificate matches exact name first, then wildcard, and falls back to the first certificate
+func (s *certStore) getCertificate(hello *gotls.ClientHelloInfo) (*gotls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name != "" {
+		if cert, ok := s.byName[name]; ok {
+			return cert, nil
+		}
+		if idx := strings.IndexByte(name, '.'); idx > 0 {
+			if cert, ok := s.byName["*"+name[idx:]]; ok {
+				return cert, nil
+			}
+		}
+	}
+	return s.certs[0], nil
+}
@@ -0,0 +1,77 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	gotls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeCert(t *testing.T, dir, name string, dnsNames ...string) {
+	should := require.New(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	should.NoError(err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	should.NoError(err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	should.NoError(err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	should.NoError(ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600))
+	should.NoError(ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600))
+}
+
+func TestNewTLSConfig_SNI(t *testing.T) {
+	should := require.New(t)
+	dir, err := ioutil.TempDir("", "durian_tls")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+	writeCert(t, dir, "a", "a.com")
+	writeCert(t, dir, "b", "*.b.com")
+
+	tlsCfg, err := NewTLSConfig(&TLSConfig{CertDir: dir, MinVersion: defaultMinVersion})
+	should.NoError(err)
+
+	var testCases = []struct {
+		serverName string
+		expect     string
+	}{
+		{"a.com", "a.com"},
+		{"A.COM", "a.com"},
+		{"www.b.com", "*.b.com"},
+		{"unknown.org", "a.com"},
+		{"", "a.com"},
+	}
+	for _, tc := range testCases {
+		cert, err := tlsCfg.GetCertificate(&gotls.ClientHelloInfo{ServerName: tc.serverName})
+		should.NoError(err)
+		should.Equal(tc.expect, cert.Leaf.DNSNames[0], "serverName %s", tc.serverName)
+	}
+}
+
+func TestNewTLSConfig_NoCertificate(t *testing.T) {
+	should := require.New(t)
+	dir, err := ioutil.TempDir("", "durian_tls")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+	_, err = NewTLSConfig(&TLSConfig{CertDir: dir})
+	should.Equal(errNoCertificate, err)
+}
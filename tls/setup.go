@@ -0,0 +1,171 @@
+package tls
+
+import (
+	gotls "crypto/tls"
+	"strings"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/mholt/caddy"
+)
+
+func init() {
+	caddy.RegisterPlugin(super.DirectiveTLS, caddy.Plugin{
+		ServerType: super.FastHTTPServerType,
+		Action:     setup,
+	})
+}
+
+// TLSConfig is the user facing config of tls directive
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	CertDir      string
+	MinVersion   uint16
+	CipherSuites []uint16
+	ClientCA     string
+	ClientAuth   gotls.ClientAuthType
+}
+
+func setup(c *caddy.Controller) error {
+	cfg, err := parseTLS(c)
+	if err != nil {
+		return err
+	}
+	srvCfg := super.GetConfig(c)
+	if srvCfg == nil {
+		return c.Errf("[tls] couldn't find %s's config", c.Key)
+	}
+	// certificates are (re)loaded every time the Caddyfile is executed,
+	// so a graceful restart picks up the renewed files
+	tlsCfg, err := NewTLSConfig(cfg)
+	if err != nil {
+		return c.Errf("[tls] %s", err)
+	}
+	srvCfg.TLS = tlsCfg
+	return nil
+}
+
+var (
+	versions = map[string]uint16{
+		"tls1.0": gotls.VersionTLS10,
+		"tls1.1": gotls.VersionTLS11,
+		"tls1.2": gotls.VersionTLS12,
+		"tls1.3": gotls.VersionTLS13,
+	}
+	clientAuthTypes = map[string]gotls.ClientAuthType{
+		"request":            gotls.RequestClientCert,
+		"require":            gotls.RequireAnyClientCert,
+		"verify_if_given":    gotls.VerifyClientCertIfGiven,
+		"require_and_verify": gotls.RequireAndVerifyClientCert,
+	}
+	cipherSuites = map[string]uint16{
+		"TLS_RSA_WITH_AES_128_GCM_SHA256":         gotls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		"TLS_RSA_WITH_AES_256_GCM_SHA384":         gotls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": gotls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": gotls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   gotls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   gotls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  gotls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    gotls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    gotls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+		"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    gotls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      gotls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      gotls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		"TLS_RSA_WITH_AES_128_CBC_SHA":            gotls.TLS_RSA_WITH_AES_128_CBC_SHA,
+		"TLS_RSA_WITH_AES_256_CBC_SHA":            gotls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	}
+	defaultMinVersion = uint16(gotls.VersionTLS12)
+)
+
+// tls cert_file key_file
+//
+//	tls {
+//	    cert cert_file
+//	    key key_file
+//	    cert_dir /etc/durian/certs
+//	    min_version tls1.2
+//	    ciphers TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 ...
+//	    client_ca ca_file
+//	    client_auth require_and_verify
+//	}
+func parseTLS(c *caddy.Controller) (*TLSConfig, error) {
+	// skip tls keyword
+	c.Next()
+
+	cfg := TLSConfig{MinVersion: defaultMinVersion}
+	args := c.RemainingArgs()
+	switch len(args) {
+	case 0:
+	case 2:
+		cfg.CertFile, cfg.KeyFile = args[0], args[1]
+	default:
+		return nil, c.ArgErr()
+	}
+	for c.NextBlock() {
+		kind := c.Val()
+		switch strings.ToLower(kind) {
+		case "cert":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			cfg.CertFile = c.Val()
+		case "key":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			cfg.KeyFile = c.Val()
+		case "cert_dir":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			cfg.CertDir = c.Val()
+		case "min_version":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			v, ok := versions[strings.ToLower(c.Val())]
+			if !ok {
+				return nil, c.Errf("[tls] unsupported version %s", c.Val())
+			}
+			cfg.MinVersion = v
+		case "ciphers":
+			names := c.RemainingArgs()
+			if len(names) == 0 {
+				return nil, c.ArgErr()
+			}
+			for _, name := range names {
+				id, ok := cipherSuites[strings.ToUpper(name)]
+				if !ok {
+					return nil, c.Errf("[tls] unsupported cipher suite %s", name)
+				}
+				cfg.CipherSuites = append(cfg.CipherSuites, id)
+			}
+		case "client_ca":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			cfg.ClientCA = c.Val()
+			if cfg.ClientAuth == gotls.NoClientCert {
+				cfg.ClientAuth = gotls.RequireAndVerifyClientCert
+			}
+		case "client_auth":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			t, ok := clientAuthTypes[strings.ToLower(c.Val())]
+			if !ok {
+				return nil, c.Errf("[tls] unsupported client_auth %s", c.Val())
+			}
+			cfg.ClientAuth = t
+		default:
+			return nil, c.Errf("[tls] illegal directive %s", kind)
+		}
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, c.Err("[tls] cert and key must be specified together")
+	}
+	if cfg.CertFile == "" && cfg.CertDir == "" {
+		return nil, c.Err("[tls] either cert/key or cert_dir is required")
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,183 @@
+package upstream
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/caibirdme/durian/replace"
+	"github.com/valyala/fasthttp"
+)
+
+// Balancer picks one of the candidates for the request
+type Balancer interface {
+	Pick(ctx *fasthttp.RequestCtx, candidates []*Peer) *Peer
+}
+
+// PolicyFactory creates a Balancer with the arguments following the policy name
+type PolicyFactory func(args []string) (Balancer, error)
+
+const (
+	PolicyRoundRobin = "round_robin"
+	PolicyLeastConn  = "least_conn"
+	PolicyRandomTwo  = "random_two"
+	PolicyRandom     = "random"
+	PolicyHash       = "hash"
+)
+
+var policies = map[string]PolicyFactory{
+	PolicyRoundRobin: func(args []string) (Balancer, error) { return newWeightedRoundRobin(), nil },
+	PolicyLeastConn:  func(args []string) (Balancer, error) { return &leastConn{}, nil },
+	PolicyRandomTwo:  func(args []string) (Balancer, error) { return &randomTwoChoices{}, nil },
+	PolicyRandom:     func(args []string) (Balancer, error) { return &weightedRandom{}, nil },
+	PolicyHash:       newHash,
+}
+
+// RegisterPolicy makes a balancing policy available to proxy and fastcgi
+func RegisterPolicy(name string, factory PolicyFactory) {
+	policies[name] = factory
+}
+
+// NewBalancer returns the balancer of the named policy
+func NewBalancer(name string, args []string) (Balancer, error) {
+	factory, ok := policies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown policy %s", name)
+	}
+	return factory(args)
+}
+
+// weightedRoundRobin is the smooth weighted round-robin used by nginx
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[*Peer]int
+}
+
+func newWeightedRoundRobin() *weightedRoundRobin {
+	return &weightedRoundRobin{current: make(map[*Peer]int)}
+}
+
+func (w *weightedRoundRobin) Pick(_ *fasthttp.RequestCtx, candidates []*Peer) *Peer {
+	if len(candidates) == 0 {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var best *Peer
+	total := 0
+	for _, p := range candidates {
+		weight := p.weight()
+		total += weight
+		w.current[p] += weight
+		if best == nil || w.current[p] > w.current[best] {
+			best = p
+		}
+	}
+	w.current[best] -= total
+	return best
+}
+
+// leastConn picks the peer with the fewest requests in flight relative to its weight
+type leastConn struct{}
+
+func (leastConn) Pick(_ *fasthttp.RequestCtx, candidates []*Peer) *Peer {
+	var best *Peer
+	bestScore := math.MaxFloat64
+	for _, p := range candidates {
+		score := float64(p.Active()) / float64(p.weight())
+		if score < bestScore {
+			best, bestScore = p, score
+		}
+	}
+	return best
+}
+
+// randomTwoChoices picks two peers randomly and uses the less loaded one
+type randomTwoChoices struct{}
+
+func (randomTwoChoices) Pick(_ *fasthttp.RequestCtx, candidates []*Peer) *Peer {
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		return candidates[0]
+	}
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := candidates[i], candidates[j]
+	if float64(b.Active())/float64(b.weight()) < float64(a.Active())/float64(a.weight()) {
+		return b
+	}
+	return a
+}
+
+type weightedRandom struct{}
+
+func (weightedRandom) Pick(_ *fasthttp.RequestCtx, candidates []*Peer) *Peer {
+	if len(candidates) == 0 {
+		return nil
+	}
+	total := 0
+	for _, p := range candidates {
+		total += p.weight()
+	}
+	n := rand.Intn(total)
+	for _, p := range candidates {
+		n -= p.weight()
+		if n < 0 {
+			return p
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// hash uses weighted rendezvous hashing, so only the keys on a removed peer are remapped
+type hash struct {
+	key       string
+	templates *replace.VariablePlaceholder
+}
+
+func newHash(args []string) (Balancer, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("policy hash requires exactly one key, such as {~session}")
+	}
+	h := &hash{key: args[0], templates: replace.NewVariablePlaceholder()}
+	h.templates.SetTmpl(h.key)
+	return h, nil
+}
+
+func (h *hash) Pick(ctx *fasthttp.RequestCtx, candidates []*Peer) *Peer {
+	if len(candidates) == 0 {
+		return nil
+	}
+	key, err := h.templates.ExecuteFuncString(h.key, func(w io.Writer, tag string) (int, error) {
+		n, err := replace.ReplaceVariable(ctx, w, tag)
+		if err == replace.ErrNotBuiltin {
+			return 0, nil
+		}
+		return n, err
+	})
+	if err != nil {
+		return candidates[0]
+	}
+	var best *Peer
+	bestScore := -math.MaxFloat64
+	for _, p := range candidates {
+		f := fnv.New64a()
+		io.WriteString(f, key)
+		io.WriteString(f, p.Addr)
+		// map the hash into (0, 1) and weight it, see "Weighted Distributed Hash Tables"
+		u := (float64(f.Sum64()>>11) + 0.5) / (1 << 53)
+		score := -float64(p.weight()) / math.Log(u)
+		if score > bestScore {
+			best, bestScore = p, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,83 @@
+package upstream
+
+import (
+	"testing"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func newTestGroup(weights ...int) *Group {
+	u := super.Upstream{Name: "test"}
+	for i, w := range weights {
+		u.Backends = append(u.Backends, super.Backend{Network: "tcp", Addr: string('a' + byte(i)), Weight: w})
+	}
+	return NewGroup(u)
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	should := require.New(t)
+	g := newTestGroup(5, 1, 1)
+	b, err := NewBalancer(PolicyRoundRobin, nil)
+	should.NoError(err)
+	var picked []string
+	for i := 0; i < 7; i++ {
+		picked = append(picked, b.Pick(nil, g.Candidates()).Addr)
+	}
+	// the same sequence as nginx's smooth weighted round-robin
+	should.Equal([]string{"a", "a", "b", "a", "c", "a", "a"}, picked)
+}
+
+func TestLeastConn(t *testing.T) {
+	should := require.New(t)
+	g := newTestGroup(1, 1, 2)
+	b, err := NewBalancer(PolicyLeastConn, nil)
+	should.NoError(err)
+	g.Primary[0].Acquire()
+	g.Primary[2].Acquire()
+	should.Equal("b", b.Pick(nil, g.Candidates()).Addr)
+	g.Primary[1].Acquire()
+	// c has double weight
+	should.Equal("c", b.Pick(nil, g.Candidates()).Addr)
+}
+
+func TestHash(t *testing.T) {
+	should := require.New(t)
+	g := newTestGroup(1, 1, 1, 1)
+	_, err := NewBalancer(PolicyHash, nil)
+	should.Error(err)
+	b, err := NewBalancer(PolicyHash, []string{"{~session}"})
+	should.NoError(err)
+
+	pick := func(session string, candidates []*Peer) string {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetCookie("session", session)
+		return b.Pick(ctx, candidates).Addr
+	}
+	sessions := []string{"s1", "s2", "s3", "s4", "s5", "s6", "s7", "s8"}
+	before := make(map[string]string)
+	for _, s := range sessions {
+		before[s] = pick(s, g.Candidates())
+		should.Equal(before[s], pick(s, g.Candidates()))
+	}
+	// removing one peer only remaps the sessions on it
+	removed := g.Primary[0].Addr
+	for _, s := range sessions {
+		after := pick(s, g.Candidates()[1:])
+		if before[s] != removed {
+			should.Equal(before[s], after)
+		}
+	}
+}
+
+func TestGroup_Candidates(t *testing.T) {
+	should := require.New(t)
+	g := NewGroup(super.Upstream{Backends: []super.Backend{
+		{Addr: "primary", Weight: 1},
+		{Addr: "backup", Weight: 1, Backup: true},
+	}})
+	should.Len(g.Candidates(), 1)
+	should.Equal("primary", g.Candidates()[0].Addr)
+	should.Len(g.Peers(), 2)
+}
@@ -0,0 +1,247 @@
+package upstream
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caibirdme/durian/log"
+	super "github.com/caibirdme/durian/server"
+	"github.com/mholt/caddy"
+	"go.uber.org/zap"
+)
+
+// Peer is a backend with runtime state, it's shared by all the directives referencing the same upstream
+type Peer struct {
+	super.Backend
+	active int64
+	// failures is the total number of failed requests
+	failures uint64
+	// unhealthy is set by the active health checker
+	unhealthy int32
+
+	mu        sync.Mutex
+	fails     int
+	failStart time.Time
+	downUntil int64
+	// consecutive probe results, only accessed by the health checker
+	probeOK   int
+	probeFail int
+}
+
+// Acquire marks a request in flight, Release must be called once it's done
+func (p *Peer) Acquire() {
+	atomic.AddInt64(&p.active, 1)
+}
+
+func (p *Peer) Release() {
+	atomic.AddInt64(&p.active, -1)
+}
+
+// Active returns the number of requests in flight
+func (p *Peer) Active() int64 {
+	return atomic.LoadInt64(&p.active)
+}
+
+// Available reports whether the peer can be selected
+func (p *Peer) Available() bool {
+	if atomic.LoadInt32(&p.unhealthy) == 1 {
+		return false
+	}
+	return time.Now().UnixNano() >= atomic.LoadInt64(&p.downUntil)
+}
+
+// Healthy reports the result of active health check
+func (p *Peer) Healthy() bool {
+	return atomic.LoadInt32(&p.unhealthy) == 0
+}
+
+// Fails returns the number of failures in the current fail_timeout window
+func (p *Peer) Fails() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fails
+}
+
+// Failures returns the total number of failed requests
+func (p *Peer) Failures() uint64 {
+	return atomic.LoadUint64(&p.failures)
+}
+
+// MarkFailure records a failed request, the peer is ejected for FailTimeout
+// once it fails MaxFails times within FailTimeout, like nginx does
+func (p *Peer) MarkFailure() {
+	atomic.AddUint64(&p.failures, 1)
+	if p.MaxFails <= 0 {
+		return
+	}
+	now := time.Now()
+	p.mu.Lock()
+	if p.fails == 0 || now.Sub(p.failStart) > p.FailTimeout {
+		p.fails, p.failStart = 0, now
+	}
+	p.fails++
+	ejected := p.fails >= p.MaxFails
+	if ejected {
+		p.fails = 0
+		atomic.StoreInt64(&p.downUntil, now.Add(p.FailTimeout).UnixNano())
+	}
+	p.mu.Unlock()
+	if ejected {
+		log.GetLogger().Warn("[upstream] backend is temporarily unavailable",
+			zap.String("addr", p.Addr),
+			zap.Int("max_fails", p.MaxFails),
+			zap.Duration("fail_timeout", p.FailTimeout),
+		)
+	}
+}
+
+// MarkSuccess records a successful request
+func (p *Peer) MarkSuccess() {
+	if p.MaxFails <= 0 {
+		return
+	}
+	p.mu.Lock()
+	p.fails = 0
+	p.mu.Unlock()
+}
+
+func (p *Peer) setHealthy(healthy bool) (changed bool) {
+	var v int32
+	if !healthy {
+		v = 1
+	}
+	return atomic.SwapInt32(&p.unhealthy, v) != v
+}
+
+func (p *Peer) weight() int {
+	if p.Weight <= 0 {
+		return 1
+	}
+	return p.Weight
+}
+
+// Group is the runtime representation of super.Upstream
+type Group struct {
+	Name        string
+	Primary     []*Peer
+	Backup      []*Peer
+	healthCheck super.HealthCheck
+}
+
+// NewGroup ignores max_fails of the only primary peer without backups like nginx,
+// ejecting it would make the whole group unavailable for fail_timeout
+func NewGroup(u super.Upstream) *Group {
+	g := &Group{Name: u.Name, healthCheck: u.HealthCheck}
+	for _, b := range u.Backends {
+		p := &Peer{Backend: b}
+		if b.Backup {
+			g.Backup = append(g.Backup, p)
+		} else {
+			g.Primary = append(g.Primary, p)
+		}
+	}
+	if len(g.Primary) == 1 && len(g.Backup) == 0 {
+		g.Primary[0].MaxFails = 0
+	}
+	return g
+}
+
+// Peers returns all the primary and backup peers
+func (g *Group) Peers() []*Peer {
+	peers := make([]*Peer, 0, len(g.Primary)+len(g.Backup))
+	peers = append(peers, g.Primary...)
+	return append(peers, g.Backup...)
+}
+
+// Candidates returns available primary peers, backups are only returned when all primaries are down
+func (g *Group) Candidates() []*Peer {
+	if peers := available(g.Primary); len(peers) > 0 {
+		return peers
+	}
+	return available(g.Backup)
+}
+
+// Untried returns candidates except the tried ones, backups are returned when all available primaries have been tried
+func (g *Group) Untried(tried []*Peer) []*Peer {
+	if len(tried) == 0 {
+		return g.Candidates()
+	}
+	if peers := exclude(available(g.Primary), tried); len(peers) > 0 {
+		return peers
+	}
+	return exclude(available(g.Backup), tried)
+}
+
+func available(peers []*Peer) []*Peer {
+	res := make([]*Peer, 0, len(peers))
+	for _, p := range peers {
+		if p.Available() {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func exclude(peers []*Peer, tried []*Peer) []*Peer {
+	res := peers[:0]
+outer:
+	for _, p := range peers {
+		for _, t := range tried {
+			if p == t {
+				continue outer
+			}
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// GetGroup returns the group defined by upstream directive
+func GetGroup(c *caddy.Controller, name string) (*Group, bool) {
+	v := c.Get(super.UpstreamGroupKey)
+	if v == nil {
+		return nil, false
+	}
+	g, ok := v.(map[string]*Group)[name]
+	return g, ok
+}
+
+// TrackGroup records the anonymous group defined inside a directive, so that it's reported by Groups.
+// Directives run once per site, so the group is renamed like :8080/proxy#0 to tell the same address lists apart
+func TrackGroup(c *caddy.Controller, directive string, g *Group) {
+	groups, _ := c.Get(super.AnonymousGroupKey).([]*Group)
+	prefix := c.Key + "/" + directive + "#"
+	n := 0
+	for _, tracked := range groups {
+		if strings.HasPrefix(tracked.Name, prefix) {
+			n++
+		}
+	}
+	g.Name = prefix + strconv.Itoa(n)
+	c.Set(super.AnonymousGroupKey, append(groups, g))
+}
+
+// Groups returns all the named groups sorted by name, followed by the tracked anonymous ones
+func Groups(c *caddy.Controller) []*Group {
+	named, _ := c.Get(super.UpstreamGroupKey).(map[string]*Group)
+	groups := make([]*Group, 0, len(named))
+	for _, g := range named {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	anonymous, _ := c.Get(super.AnonymousGroupKey).([]*Group)
+	return append(groups, anonymous...)
+}
+
+func setGroup(c *caddy.Controller, g *Group) {
+	m, ok := c.Get(super.UpstreamGroupKey).(map[string]*Group)
+	if !ok {
+		m = make(map[string]*Group)
+	}
+	m[g.Name] = g
+	c.Set(super.UpstreamGroupKey, m)
+}
@@ -0,0 +1,215 @@
+package upstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/caibirdme/durian/log"
+	super "github.com/caibirdme/durian/server"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHealthInterval = 10 * time.Second
+	defaultHealthTimeout  = 2 * time.Second
+	defaultHealthRise     = 2
+	defaultHealthFall     = 3
+	defaultHealthURI      = "/"
+)
+
+var (
+	errUnexpectedStatus = errors.New("unexpected status code")
+	errBadFCGIResponse  = errors.New("unexpected fastcgi response")
+)
+
+// healthChecker probes all the peers of a group periodically
+type healthChecker struct {
+	group *Group
+	cfg   super.HealthCheck
+	stop  chan struct{}
+}
+
+func newHealthChecker(g *Group) *healthChecker {
+	cfg := g.healthCheck
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultHealthInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHealthTimeout
+	}
+	if cfg.Rise <= 0 {
+		cfg.Rise = defaultHealthRise
+	}
+	if cfg.Fall <= 0 {
+		cfg.Fall = defaultHealthFall
+	}
+	if cfg.URI == "" {
+		cfg.URI = defaultHealthURI
+	}
+	return &healthChecker{group: g, cfg: cfg, stop: make(chan struct{})}
+}
+
+func (h *healthChecker) Start() error {
+	go h.run()
+	return nil
+}
+
+func (h *healthChecker) Stop() error {
+	close(h.stop)
+	return nil
+}
+
+func (h *healthChecker) run() {
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+	h.checkAll()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			h.checkAll()
+		}
+	}
+}
+
+func (h *healthChecker) checkAll() {
+	for _, p := range h.group.Peers() {
+		h.check(p)
+	}
+}
+
+func (h *healthChecker) check(p *Peer) {
+	err := h.probe(p)
+	if err == nil {
+		p.probeOK, p.probeFail = p.probeOK+1, 0
+		if p.probeOK >= h.cfg.Rise && p.setHealthy(true) {
+			log.GetLogger().Info("[upstream] backend is healthy",
+				zap.String("upstream", h.group.Name),
+				zap.String("addr", p.Addr),
+			)
+		}
+		return
+	}
+	p.probeOK, p.probeFail = 0, p.probeFail+1
+	if p.probeFail >= h.cfg.Fall && p.setHealthy(false) {
+		log.GetLogger().Warn("[upstream] backend is unhealthy",
+			zap.String("upstream", h.group.Name),
+			zap.String("addr", p.Addr),
+			zap.String("type", h.cfg.Type),
+			zap.Error(err),
+		)
+	}
+}
+
+func (h *healthChecker) probe(p *Peer) error {
+	switch h.cfg.Type {
+	case super.HealthCheckHTTP:
+		return h.probeHTTP(p)
+	case super.HealthCheckFastCGI:
+		return h.probeFastCGI(p)
+	default:
+		conn, err := net.DialTimeout(p.Network, p.Addr, h.cfg.Timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+func (h *healthChecker) probeHTTP(p *Peer) error {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(h.cfg.URI)
+	req.SetHost(p.Addr)
+	client := &fasthttp.HostClient{
+		Addr: p.Addr,
+		Dial: func(addr string) (net.Conn, error) {
+			return net.DialTimeout(p.Network, addr, h.cfg.Timeout)
+		},
+	}
+	if err := client.DoTimeout(req, resp, h.cfg.Timeout); err != nil {
+		return err
+	}
+	if code := resp.StatusCode(); code < 200 || code >= 400 {
+		return fmt.Errorf("%s: %d", errUnexpectedStatus, code)
+	}
+	return nil
+}
+
+// probeFastCGI sends FCGI_GET_VALUES, which is answered by the fastcgi
+// process manager itself without executing any script
+func (h *healthChecker) probeFastCGI(p *Peer) error {
+	conn, err := net.DialTimeout(p.Network, p.Addr, h.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(h.cfg.Timeout)); err != nil {
+		return err
+	}
+	const (
+		fcgiVersion         = 1
+		fcgiGetValues       = 9
+		fcgiGetValuesResult = 10
+	)
+	name := "FCGI_MPXS_CONNS"
+	content := append([]byte{byte(len(name)), 0}, name...)
+	padding := -len(content) & 7
+	record := make([]byte, 8, 8+len(content)+padding)
+	record[0], record[1] = fcgiVersion, fcgiGetValues
+	binary.BigEndian.PutUint16(record[4:6], uint16(len(content)))
+	record[6] = byte(padding)
+	record = append(record, content...)
+	record = append(record, make([]byte, padding)...)
+	if _, err = conn.Write(record); err != nil {
+		return err
+	}
+	var header [8]byte
+	if _, err = io.ReadFull(conn, header[:]); err != nil {
+		return err
+	}
+	if header[0] != fcgiVersion || header[1] != fcgiGetValuesResult {
+		return errBadFCGIResponse
+	}
+	return nil
+}
+
+type PeerStatus struct {
+	Addr      string `json:"addr"`
+	Weight    int    `json:"weight"`
+	Backup    bool   `json:"backup"`
+	Active    int64  `json:"active"`
+	Fails     int    `json:"fails"`
+	Healthy   bool   `json:"healthy"`
+	Available bool   `json:"available"`
+}
+
+type GroupStatus struct {
+	Name  string       `json:"name"`
+	Peers []PeerStatus `json:"peers"`
+}
+
+// Status returns a snapshot of all the peers in the group
+func (g *Group) Status() GroupStatus {
+	st := GroupStatus{Name: g.Name}
+	for _, p := range g.Peers() {
+		st.Peers = append(st.Peers, PeerStatus{
+			Addr:      p.Addr,
+			Weight:    p.weight(),
+			Backup:    p.Backup,
+			Active:    p.Active(),
+			Fails:     p.Fails(),
+			Healthy:   p.Healthy(),
+			Available: p.Available(),
+		})
+	}
+	return st
+}
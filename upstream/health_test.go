@@ -0,0 +1,63 @@
+package upstream
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeer_MarkFailure(t *testing.T) {
+	should := require.New(t)
+	g := NewGroup(super.Upstream{Backends: []super.Backend{
+		{Addr: "primary", Weight: 1, MaxFails: 2, FailTimeout: 50 * time.Millisecond},
+		{Addr: "backup", Weight: 1, Backup: true},
+	}})
+	p := g.Primary[0]
+	p.MarkFailure()
+	should.True(p.Available())
+	p.MarkSuccess()
+	p.MarkFailure()
+	should.True(p.Available())
+	p.MarkFailure()
+	should.False(p.Available())
+	// backups are used only when all primaries are down
+	should.Equal("backup", g.Candidates()[0].Addr)
+
+	time.Sleep(60 * time.Millisecond)
+	should.True(p.Available())
+	should.Equal("primary", g.Candidates()[0].Addr)
+}
+
+func TestHealthChecker(t *testing.T) {
+	should := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	g := NewGroup(super.Upstream{
+		Backends:    []super.Backend{{Network: "tcp", Addr: ln.Addr().String(), Weight: 1}},
+		HealthCheck: super.HealthCheck{Type: super.HealthCheckTCP, Rise: 1, Fall: 1, Timeout: time.Second},
+	})
+	h := newHealthChecker(g)
+	p := g.Primary[0]
+	h.check(p)
+	should.True(p.Healthy())
+
+	ln.Close()
+	h.check(p)
+	should.False(p.Healthy())
+	should.False(p.Available())
+	should.Len(g.Candidates(), 0)
+	should.False(g.Status().Peers[0].Healthy)
+}
@@ -0,0 +1,157 @@
+package upstream
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryCondition is a bit set of failures which can be retried on the next backend
+type RetryCondition uint
+
+const (
+	// RetryOnError covers errors occurred while connecting, sending the request or reading the response
+	RetryOnError RetryCondition = 1 << iota
+	RetryOnTimeout
+	RetryOn500
+	RetryOn502
+	RetryOn503
+	RetryOn504
+)
+
+var (
+	retryConditions = map[string]RetryCondition{
+		"error":    RetryOnError,
+		"timeout":  RetryOnTimeout,
+		"500":      RetryOn500,
+		"502":      RetryOn502,
+		"503":      RetryOn503,
+		"504":      RetryOn504,
+		"http_500": RetryOn500,
+		"http_502": RetryOn502,
+		"http_503": RetryOn503,
+		"http_504": RetryOn504,
+	}
+	statusConditions = map[int]RetryCondition{
+		500: RetryOn500,
+		502: RetryOn502,
+		503: RetryOn503,
+		504: RetryOn504,
+	}
+	nonIdempotentMethods = [][]byte{[]byte("POST"), []byte("PATCH"), []byte("LOCK")}
+)
+
+// RetryPolicy decides whether a failed try should be passed to the next backend
+type RetryPolicy struct {
+	// Tries is the total number of tries including the first one
+	Tries      int
+	Conditions RetryCondition
+	// NonIdempotent allows to retry POST, PATCH and LOCK requests
+	NonIdempotent bool
+	// TryTimeout limits a single try, Timeout limits all the tries, 0 means no limit
+	TryTimeout time.Duration
+	Timeout    time.Duration
+}
+
+// DefaultRetryPolicy doesn't retry at all
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{Tries: 1, Conditions: RetryOnError | RetryOnTimeout}
+}
+
+// ParseOption parses retry related subdirectives shared by proxy and fastcgi,
+// it returns false if kind isn't one of them
+//
+// tries 3
+// retry_on error timeout 502 503 504
+// retry_non_idempotent
+// try_timeout 1s
+// retry_timeout 5s
+func (r *RetryPolicy) ParseOption(kind string, args []string) (bool, error) {
+	switch kind {
+	case "tries":
+		if len(args) != 1 {
+			return true, fmt.Errorf("tries requires one argument")
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return true, fmt.Errorf("value of tries must be a positive int but %s", args[0])
+		}
+		r.Tries = n
+	case "retry_on":
+		if len(args) == 0 {
+			return true, fmt.Errorf("retry_on requires at least one condition")
+		}
+		r.Conditions = 0
+		for _, arg := range args {
+			if arg == "off" {
+				r.Tries = 1
+				continue
+			}
+			cond, ok := retryConditions[strings.ToLower(arg)]
+			if !ok {
+				return true, fmt.Errorf("unknown retry condition %s", arg)
+			}
+			r.Conditions |= cond
+		}
+	case "retry_non_idempotent":
+		r.NonIdempotent = true
+	case "try_timeout", "retry_timeout":
+		if len(args) != 1 {
+			return true, fmt.Errorf("%s requires one argument", kind)
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return true, fmt.Errorf("%s isn't a duration: %s", kind, err)
+		}
+		if kind == "try_timeout" {
+			r.TryTimeout = d
+		} else {
+			r.Timeout = d
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// FailedStatus reports whether the status code returned by a backend is configured as a failure
+func (r *RetryPolicy) FailedStatus(code int) (RetryCondition, bool) {
+	cond, ok := statusConditions[code]
+	return cond, ok && r.Conditions&cond != 0
+}
+
+// ShouldRetry reports whether the request can be passed to the next backend after the failure cond.
+// sent is false if the request hasn't reached the backend, such as dial errors, then it's safe to retry any method
+func (r *RetryPolicy) ShouldRetry(cond RetryCondition, method []byte, sent bool, tries int, start time.Time) bool {
+	if tries >= r.Tries || r.Conditions&cond == 0 {
+		return false
+	}
+	if r.Timeout > 0 && time.Since(start) >= r.Timeout {
+		return false
+	}
+	if sent && !r.NonIdempotent {
+		for _, m := range nonIdempotentMethods {
+			if bytes.Equal(m, method) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// NextTimeout returns the timeout of the next try, which never exceeds the remaining total budget
+func (r *RetryPolicy) NextTimeout(defaultTimeout time.Duration, start time.Time) time.Duration {
+	d := defaultTimeout
+	if r.TryTimeout > 0 {
+		d = r.TryTimeout
+	}
+	if r.Timeout > 0 {
+		remain := r.Timeout - time.Since(start)
+		if d <= 0 || remain < d {
+			d = remain
+		}
+	}
+	return d
+}
@@ -0,0 +1,87 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+
+	super "github.com/caibirdme/durian/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryParseOption(t *testing.T) {
+	should := require.New(t)
+	r := DefaultRetryPolicy()
+	for _, line := range [][]string{
+		{"tries", "3"},
+		{"retry_on", "error", "http_502", "503"},
+		{"retry_non_idempotent"},
+		{"try_timeout", "1s"},
+		{"retry_timeout", "5s"},
+	} {
+		handled, err := r.ParseOption(line[0], line[1:])
+		should.NoError(err)
+		should.True(handled)
+	}
+	should.Equal(RetryPolicy{
+		Tries:         3,
+		Conditions:    RetryOnError | RetryOn502 | RetryOn503,
+		NonIdempotent: true,
+		TryTimeout:    time.Second,
+		Timeout:       5 * time.Second,
+	}, r)
+
+	handled, err := r.ParseOption("root", []string{"/var/www"})
+	should.NoError(err)
+	should.False(handled)
+	_, err = r.ParseOption("tries", []string{"0"})
+	should.Error(err)
+	_, err = r.ParseOption("retry_on", []string{"404"})
+	should.Error(err)
+}
+
+func TestShouldRetry(t *testing.T) {
+	should := require.New(t)
+	r := DefaultRetryPolicy()
+	r.Tries = 2
+	now := time.Now()
+	get, post := []byte("GET"), []byte("POST")
+	should.True(r.ShouldRetry(RetryOnError, get, true, 1, now))
+	should.False(r.ShouldRetry(RetryOnError, get, true, 2, now))
+	should.False(r.ShouldRetry(RetryOn502, get, true, 1, now))
+	should.False(r.ShouldRetry(RetryOnError, post, true, 1, now))
+	// the connection is refused, so the POST request never reaches the backend
+	should.True(r.ShouldRetry(RetryOnError, post, false, 1, now))
+	should.False(r.ShouldRetry(RetryOnError, post, false, 2, now))
+	r.NonIdempotent = true
+	should.True(r.ShouldRetry(RetryOnError, post, true, 1, now))
+
+	r.Timeout = time.Second
+	should.False(r.ShouldRetry(RetryOnError, get, true, 1, now.Add(-2*time.Second)))
+	should.Equal(100*time.Millisecond, r.NextTimeout(100*time.Millisecond, now))
+	should.True(r.NextTimeout(0, now.Add(-900*time.Millisecond)) <= 100*time.Millisecond)
+
+	_, failed := r.FailedStatus(502)
+	should.False(failed)
+	r.Conditions |= RetryOn502
+	cond, failed := r.FailedStatus(502)
+	should.True(failed)
+	should.Equal(RetryOn502, cond)
+}
+
+func TestUntried(t *testing.T) {
+	should := require.New(t)
+	g := NewGroup(super.Upstream{Backends: []super.Backend{
+		{Addr: "a", Weight: 1},
+		{Addr: "b", Weight: 1},
+		{Addr: "c", Weight: 1, Backup: true},
+	}})
+	should.Len(g.Untried(nil), 2)
+	peers := g.Untried([]*Peer{g.Primary[0]})
+	should.Len(peers, 1)
+	should.Equal("b", peers[0].Addr)
+	// fail over to backups once all primaries are tried
+	peers = g.Untried(g.Primary)
+	should.Len(peers, 1)
+	should.Equal("c", peers[0].Addr)
+	should.Empty(g.Untried(g.Peers()))
+}
@@ -1,11 +1,15 @@
 package upstream
 
 import (
+	"encoding/json"
 	super "github.com/caibirdme/durian/server"
 	"github.com/mholt/caddy"
 	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func init() {
@@ -13,31 +17,58 @@ func init() {
 		ServerType: super.FastHTTPServerType,
 		Action:     setup,
 	})
+	caddy.RegisterPlugin(super.DirectiveUpstreamStatus, caddy.Plugin{
+		ServerType: super.FastHTTPServerType,
+		Action:     setupStatus,
+	})
 }
 
+const (
+	// DefaultMaxFails and DefaultFailTimeout are the same as nginx's
+	DefaultMaxFails    = 1
+	DefaultFailTimeout = 10 * time.Second
+)
+
 func setup(c *caddy.Controller) error {
-	u, err := parseUpstream(c)
-	if err != nil {
-		return err
-	}
-	upstreamManager := c.Get(super.UpstreamKey)
-	if upstreamManager != nil {
-		m, ok := upstreamManager.(map[string]super.Upstream)
-		if !ok {
-			return errors.New("[Bug] upstreamManager isn't map[string]Upstream")
+	// upstreams are shared by the whole instance, so don't register them for every key
+	return c.OncePerServerBlock(func() error {
+		// all the upstream directives in a server block are dispensed together
+		for c.Next() {
+			u, err := parseUpstream(c)
+			if err != nil {
+				return err
+			}
+			m, ok := c.Get(super.UpstreamKey).(map[string]super.Upstream)
+			if !ok {
+				if c.Get(super.UpstreamKey) != nil {
+					return errors.New("[Bug] upstreamManager isn't map[string]Upstream")
+				}
+				m = make(map[string]super.Upstream)
+			}
+			m[u.Name] = *u
+			c.Set(super.UpstreamKey, m)
+			g := NewGroup(*u)
+			setGroup(c, g)
+			if u.HealthCheck.Type != "" {
+				checker := newHealthChecker(g)
+				c.OnStartup(checker.Start)
+				c.OnShutdown(checker.Stop)
+			}
 		}
-		m[u.Name] = *u
-		c.Set(super.UpstreamKey, m)
-	}
-	m := make(map[string]super.Upstream)
-	m[u.Name] = *u
-	c.Set(super.UpstreamKey, m)
-	return nil
+		return nil
+	})
 }
 
+//	upstream name {
+//	    10.0.0.1:80 weight=2 max_fails=3 fail_timeout=30s
+//	    unix:/var/run/php-fpm.sock backup
+//	    health_check http /ping
+//	    health_interval 5s
+//	    health_timeout 1s
+//	    health_rise 2
+//	    health_fall 3
+//	}
 func parseUpstream(c *caddy.Controller) (*super.Upstream, error) {
-	c.Next()
-
 	if !c.NextArg() {
 		return nil, c.ArgErr()
 	}
@@ -52,7 +83,13 @@ func parseUpstream(c *caddy.Controller) (*super.Upstream, error) {
 		if len(str_list) == 0 {
 			return nil, c.ArgErr()
 		}
-		b := super.Backend{}
+		if strings.HasPrefix(str_list[0], "health_") {
+			if err := parseHealthCheck(c, str_list, &u.HealthCheck); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		b := super.Backend{Weight: 1, MaxFails: DefaultMaxFails, FailTimeout: DefaultFailTimeout}
 		if strings.HasPrefix(str_list[0], "unix:") {
 			b.Network = "unix"
 			b.Addr = str_list[0][5:]
@@ -65,6 +102,7 @@ func parseUpstream(c *caddy.Controller) (*super.Upstream, error) {
 			if len(kv) == 1 {
 				switch kv[0] {
 				case "backup":
+					b.Backup = true
 				default:
 					return nil, c.Errf("%s should be in the form of k=v", str_list[i])
 				}
@@ -76,7 +114,22 @@ func parseUpstream(c *caddy.Controller) (*super.Upstream, error) {
 					if err != nil {
 						return nil, c.Errf("value of weight must be int but %s", v)
 					}
+					if weight <= 0 {
+						return nil, c.Errf("value of weight must be positive but %s", v)
+					}
 					b.Weight = weight
+				case "max_fails":
+					maxFails, err := strconv.Atoi(v)
+					if err != nil || maxFails < 0 {
+						return nil, c.Errf("value of max_fails must be a non-negative int but %s", v)
+					}
+					b.MaxFails = maxFails
+				case "fail_timeout":
+					d, err := time.ParseDuration(v)
+					if err != nil {
+						return nil, c.Errf("fail_timeout isn't a duration: %s", err)
+					}
+					b.FailTimeout = d
 				default:
 					//todo: warn log
 				}
@@ -84,5 +137,80 @@ func parseUpstream(c *caddy.Controller) (*super.Upstream, error) {
 		}
 		u.Backends = append(u.Backends, b)
 	}
+	if len(u.Backends) == 0 {
+		return nil, c.Errf("upstream %s should contain at least one backend", u.Name)
+	}
 	return &u, nil
 }
+
+func parseHealthCheck(c *caddy.Controller, list []string, hc *super.HealthCheck) error {
+	if len(list) < 2 {
+		return c.ArgErr()
+	}
+	switch list[0] {
+	case "health_check":
+		switch list[1] {
+		case super.HealthCheckHTTP, super.HealthCheckTCP, super.HealthCheckFastCGI:
+			hc.Type = list[1]
+		default:
+			return c.Errf("unsupported health_check type %s", list[1])
+		}
+		if len(list) > 2 {
+			hc.URI = list[2]
+		}
+	case "health_interval", "health_timeout":
+		d, err := time.ParseDuration(list[1])
+		if err != nil {
+			return c.Errf("%s isn't a duration: %s", list[0], err)
+		}
+		if list[0] == "health_interval" {
+			hc.Interval = d
+		} else {
+			hc.Timeout = d
+		}
+	case "health_rise", "health_fall":
+		n, err := strconv.Atoi(list[1])
+		if err != nil || n <= 0 {
+			return c.Errf("value of %s must be a positive int but %s", list[0], list[1])
+		}
+		if list[0] == "health_rise" {
+			hc.Rise = n
+		} else {
+			hc.Fall = n
+		}
+	default:
+		return c.Errf("unknown option %s", list[0])
+	}
+	return nil
+}
+
+// upstream_status /path
+func setupStatus(c *caddy.Controller) error {
+	c.Next()
+	location, err := super.NewMatcher(c, c.RemainingArgs())
+	if err != nil {
+		return c.Err(err.Error())
+	}
+	super.GetConfig(c).AddMiddleware(func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			if !location.Match(ctx) {
+				next(ctx)
+				return
+			}
+			groups, _ := c.Get(super.UpstreamGroupKey).(map[string]*Group)
+			status := make([]GroupStatus, 0, len(groups))
+			for _, g := range groups {
+				status = append(status, g.Status())
+			}
+			sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+			body, err := json.Marshal(status)
+			if err != nil {
+				ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+				return
+			}
+			ctx.SetContentType("application/json")
+			ctx.SetBody(body)
+		}
+	})
+	return nil
+}